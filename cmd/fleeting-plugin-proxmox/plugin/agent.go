@@ -0,0 +1,137 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+const agentExecStatusInterval = 1 * time.Second
+
+var (
+	ErrAgentExecTimeout = errors.New("timed out waiting for command executed by qemu agent")
+	ErrAgentExecNoPID   = errors.New("qemu agent did not return pid of executed command")
+)
+
+// Result of a command executed by QEMU guest agent.
+//
+// The go-proxmox equivalent can't decode truncation flags, hence this type.
+type agentExecStatus struct {
+	Exited       proxmox.IntOrBool `json:"exited"`
+	ExitCode     int               `json:"exitcode"`
+	Signal       int               `json:"signal"`
+	OutData      string            `json:"out-data"`
+	OutTruncated proxmox.IntOrBool `json:"out-truncated"`
+	ErrData      string            `json:"err-data"`
+	ErrTruncated proxmox.IntOrBool `json:"err-truncated"`
+}
+
+// Checks if the command exited with zero code and was not killed by a signal.
+func (s *agentExecStatus) succeeded() bool {
+	return s.ExitCode == 0 && s.Signal == 0
+}
+
+// Executes command inside the VM with QEMU guest agent and waits for it to exit.
+func (ig *InstanceGroup) agentExec(ctx context.Context, vm *proxmox.VirtualMachine, command []string, timeout time.Duration) (*agentExecStatus, error) {
+	started := map[string]int{}
+
+	err := ig.proxmox.Post(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/exec", vm.Node, vm.VMID), map[string]any{
+		"command": command,
+	}, &started)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command with qemu agent on vm='%d': %w", vm.VMID, err)
+	}
+
+	pid, ok := started["pid"]
+	if !ok {
+		return nil, fmt.Errorf("%w: vm='%d'", ErrAgentExecNoPID, vm.VMID)
+	}
+
+	deadline := time.After(timeout)
+
+	for {
+		status := &agentExecStatus{}
+
+		err := ig.proxmox.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/exec-status?pid=%d", vm.Node, vm.VMID, pid), status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get status of command executed with qemu agent on vm='%d': %w", vm.VMID, err)
+		}
+
+		if status.Exited {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to wait for command executed with qemu agent on vm='%d': %w", vm.VMID, ctx.Err())
+		case <-deadline:
+			return nil, fmt.Errorf("%w: vm='%d' pid='%d'", ErrAgentExecTimeout, vm.VMID, pid)
+		case <-time.After(agentExecStatusInterval):
+		}
+	}
+}
+
+// Content of a file read by QEMU guest agent.
+type agentFileReadResult struct {
+	Content   string            `json:"content"`
+	Truncated proxmox.IntOrBool `json:"truncated"`
+}
+
+// Reads file from inside the VM with QEMU guest agent.
+func (ig *InstanceGroup) agentFileRead(ctx context.Context, vm *proxmox.VirtualMachine, path string) (*agentFileReadResult, error) {
+	result := &agentFileReadResult{}
+
+	query := url.Values{"file": []string{path}}
+
+	err := ig.proxmox.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/file-read?%s", vm.Node, vm.VMID, query.Encode()), result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file='%s' with qemu agent on vm='%d': %w", path, vm.VMID, err)
+	}
+
+	return result, nil
+}
+
+// Lists VM's network interfaces with QEMU guest agent. Unlike go-proxmox, it does not require fetching the node and the VM first.
+func (ig *InstanceGroup) agentGetNetworkInterfaces(ctx context.Context, node string, vmid uint64) ([]*proxmox.AgentNetworkIface, error) {
+	result := map[string][]*proxmox.AgentNetworkIface{}
+
+	err := ig.proxmox.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/network-get-interfaces", node, vmid), &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve instance vmid='%d' interfaces: %w", vmid, err)
+	}
+
+	return result["result"], nil
+}
+
+// Reads information about VM's operating system with QEMU guest agent.
+func (ig *InstanceGroup) agentGetOSInfo(ctx context.Context, node string, vmid uint64) (*proxmox.AgentOsInfo, error) {
+	result := map[string]*proxmox.AgentOsInfo{}
+
+	err := ig.proxmox.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/get-osinfo", node, vmid), &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve instance vmid='%d' os info: %w", vmid, err)
+	}
+
+	if result["result"] == nil {
+		return &proxmox.AgentOsInfo{}, nil
+	}
+
+	return result["result"], nil
+}
+
+// Sets password of given user inside the VM with QEMU guest agent. Password is never included in errors.
+func (ig *InstanceGroup) agentSetUserPassword(ctx context.Context, node string, vmid uint64, username, password string) error {
+	err := ig.proxmox.Post(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/set-user-password", node, vmid), map[string]any{
+		"username": username,
+		"password": password,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set password of user='%s' with qemu agent on vm='%d': %w", username, vmid, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceGroup_agentExec(t *testing.T) {
+	tests := []struct {
+		name string
+
+		status string
+
+		expectedExitCode  int
+		expectedSignal    int
+		expectedSucceeded bool
+	}{
+		{
+			name:              "Exited with zero code",
+			status:            `{"exited":1,"exitcode":0}`,
+			expectedSucceeded: true,
+		},
+		{
+			name:             "Exited with non-zero code",
+			status:           `{"exited":1,"exitcode":2}`,
+			expectedExitCode: 2,
+		},
+		{
+			name:           "Killed by signal",
+			status:         `{"exited":1,"signal":9}`,
+			expectedSignal: 9,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/nodes/pve1/qemu/100/agent/exec" {
+					_, _ = w.Write([]byte(`{"data":{"pid":42}}`))
+					return
+				}
+
+				_, _ = w.Write([]byte(`{"data":` + testCase.status + `}`))
+			}))
+			t.Cleanup(server.Close)
+
+			ig := InstanceGroup{proxmox: proxmox.NewClient(server.URL)}
+
+			status, err := ig.agentExec(context.Background(), &proxmox.VirtualMachine{Node: "pve1", VMID: 100}, []string{"true"}, time.Minute)
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedExitCode, status.ExitCode)
+			require.Equal(t, testCase.expectedSignal, status.Signal)
+			require.Equal(t, testCase.expectedSucceeded, status.succeeded())
+		})
+	}
+}
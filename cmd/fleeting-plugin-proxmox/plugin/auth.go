@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+var ErrAuthenticationFailed = errors.New("failed to authenticate to proxmox")
+
+// HTTP transport authenticating requests to Proxmox VE API with current credentials.
+// On authentication error credentials are loaded again and request is retried once with new session.
+type authTransport struct {
+	transport       http.RoundTripper
+	ticketURL       *url.URL
+	loadCredentials func() (*Credentials, error)
+
+	// Guards fields below, held for writing while authenticating so concurrent requests wait for a single new session
+	mu          sync.RWMutex
+	credentials *Credentials
+	session     *proxmox.Session
+	generation  uint64
+}
+
+// Creates authenticating transport, credentials are loaded with given function on first request.
+func newAuthTransport(transport http.RoundTripper, baseURL *url.URL, loadCredentials func() (*Credentials, error)) *authTransport {
+	return &authTransport{
+		transport:       transport,
+		ticketURL:       baseURL.JoinPath("/access/ticket"),
+		loadCredentials: loadCredentials,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *authTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	authorized, generation, err := t.authorize(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.transport.RoundTrip(authorized)
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err //nolint:wrapcheck
+	}
+
+	if request.Body != nil && request.GetBody == nil {
+		return response, nil
+	}
+
+	_, _ = io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	// Credentials might have been rotated or session expired, so retry once with fresh ones
+	if err := t.reauthenticate(request.Context(), generation); err != nil {
+		return nil, err
+	}
+
+	authorized, _, err = t.authorize(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.transport.RoundTrip(authorized) //nolint:wrapcheck
+}
+
+// Returns copy of the request with authentication headers and generation of credentials used.
+func (t *authTransport) authorize(request *http.Request) (*http.Request, uint64, error) {
+	t.mu.RLock()
+	credentials, session, generation := t.credentials, t.session, t.generation
+	t.mu.RUnlock()
+
+	if credentials == nil {
+		if err := t.reauthenticate(request.Context(), generation); err != nil {
+			return nil, 0, err
+		}
+
+		return t.authorize(request)
+	}
+
+	authorized := request.Clone(request.Context())
+
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, 0, err //nolint:wrapcheck
+		}
+
+		authorized.Body = body
+	}
+
+	authorized.Header.Del("Authorization")
+	authorized.Header.Del("Cookie")
+	authorized.Header.Del("CSRFPreventionToken")
+
+	if credentials.IsAPIToken() {
+		authorized.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", credentials.TokenID, credentials.TokenSecret))
+	} else {
+		authorized.Header.Set("Cookie", "PVEAuthCookie="+session.Ticket)
+		authorized.Header.Set("CSRFPreventionToken", session.CSRFPreventionToken)
+	}
+
+	return authorized, generation, nil
+}
+
+// Loads credentials again and acquires new session, unless it was already done after given generation.
+func (t *authTransport) reauthenticate(ctx context.Context, generation uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.generation != generation {
+		return nil
+	}
+
+	credentials, err := t.loadCredentials()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuthenticationFailed, err)
+	}
+
+	return t.authenticateLocked(ctx, credentials)
+}
+
+// Loads credentials again and acquires new session.
+func (t *authTransport) refresh(ctx context.Context) error {
+	t.mu.RLock()
+	generation := t.generation
+	t.mu.RUnlock()
+
+	return t.reauthenticate(ctx, generation)
+}
+
+// Switches to given credentials if they differ from current ones, reports if they did.
+func (t *authTransport) update(ctx context.Context, credentials *Credentials) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.credentials != nil && *t.credentials == *credentials {
+		return false, nil
+	}
+
+	return true, t.authenticateLocked(ctx, credentials)
+}
+
+// Acquires session for given credentials and starts using them, mutex must be held for writing.
+func (t *authTransport) authenticateLocked(ctx context.Context, credentials *Credentials) error {
+	var session *proxmox.Session
+
+	if !credentials.IsAPIToken() {
+		var err error
+
+		session, err = t.ticket(ctx, credentials)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.credentials = credentials
+	t.session = session
+	t.generation++
+
+	return nil
+}
+
+// Prefix of partial tickets issued when two-factor authentication must be completed.
+const partialTicketPrefix = "PVE:!tfa!"
+
+// Session ticket response, with flag set when two-factor authentication must be completed.
+type ticketResponse struct {
+	proxmox.Session
+
+	NeedTFA int `json:"NeedTFA,omitempty"`
+}
+
+// Request completing two-factor authentication with one-time password.
+type tfaChallengeResponse struct {
+	Username     string `json:"username"`
+	Realm        string `json:"realm,omitempty"`
+	Password     string `json:"password"`
+	TFAChallenge string `json:"tfa-challenge"`
+}
+
+// Acquires session ticket for given credentials, completing two-factor authentication if required.
+func (t *authTransport) ticket(ctx context.Context, credentials *Credentials) (*proxmox.Session, error) {
+	// One-time password is generated right before sending it, so it is valid for the whole request
+	proxmoxCredentials, err := credentials.proxmoxCredentials(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthenticationFailed, err)
+	}
+
+	ticket, err := t.postTicket(ctx, credentials.Username, proxmoxCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ticket.isPartial() {
+		return &ticket.Session, nil
+	}
+
+	// Partial ticket can't be used for API calls, the challenge must be answered with a one-time password
+	proxmoxCredentials, err = credentials.proxmoxCredentials(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthenticationFailed, err)
+	}
+
+	if proxmoxCredentials.Otp == "" {
+		return nil, fmt.Errorf("%w: username='%s' two-factor authentication required, but neither otp nor totp_secret is set", ErrAuthenticationFailed, credentials.Username)
+	}
+
+	ticket, err = t.postTicket(ctx, credentials.Username, tfaChallengeResponse{
+		Username:     proxmoxCredentials.Username,
+		Realm:        proxmoxCredentials.Realm,
+		Password:     "totp:" + proxmoxCredentials.Otp,
+		TFAChallenge: ticket.Ticket,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if ticket.isPartial() {
+		return nil, fmt.Errorf("%w: username='%s' two-factor authentication not completed", ErrAuthenticationFailed, credentials.Username)
+	}
+
+	return &ticket.Session, nil
+}
+
+// Requests session ticket with given body.
+func (t *authTransport) postTicket(ctx context.Context, username string, payload any) (*ticketResponse, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode credentials: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, t.ticketURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ticket request: %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	response, err := t.transport.RoundTrip(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthenticationFailed, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: username='%s' status='%s'", ErrAuthenticationFailed, username, response.Status)
+	}
+
+	result := struct {
+		Data ticketResponse `json:"data"`
+	}{}
+
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode ticket: %w", ErrAuthenticationFailed, err)
+	}
+
+	if result.Data.Ticket == "" {
+		return nil, fmt.Errorf("%w: username='%s' no ticket received", ErrAuthenticationFailed, username)
+	}
+
+	return &result.Data, nil
+}
+
+// Checks if ticket only allows to complete two-factor authentication.
+func (r *ticketResponse) isPartial() bool {
+	return r.NeedTFA != 0 || strings.HasPrefix(r.Ticket, partialTicketPrefix)
+}
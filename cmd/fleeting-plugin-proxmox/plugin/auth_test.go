@@ -0,0 +1,245 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+// Fake Proxmox VE API accepting single password and only the latest ticket issued for it.
+type fakeAuthServer struct {
+	mu       sync.Mutex
+	password string
+	ticket   string
+
+	ticketRequests atomic.Int32
+}
+
+func (s *fakeAuthServer) setPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.password = password
+	s.ticket = ""
+}
+
+func (s *fakeAuthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/api2/json/access/ticket" {
+		n := s.ticketRequests.Add(1)
+
+		credentials := proxmox.Credentials{}
+		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil || credentials.Password != s.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		s.ticket = fmt.Sprintf("ticket-%d", n)
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": proxmox.Session{Ticket: s.ticket, CSRFPreventionToken: "csrf"}})
+
+		return
+	}
+
+	if s.ticket == "" || r.Header.Get("Cookie") != "PVEAuthCookie="+s.ticket || r.Header.Get("CSRFPreventionToken") != "csrf" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Returns client authenticating with given password and URL of a request which requires authentication.
+func newAuthTestClient(t *testing.T, server *fakeAuthServer, password *atomic.Value) (*http.Client, *authTransport, string) {
+	t.Helper()
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	baseURL, err := url.Parse(httpServer.URL + "/api2/json")
+	require.NoError(t, err)
+
+	transport := newAuthTransport(http.DefaultTransport, baseURL, func() (*Credentials, error) {
+		return &Credentials{Username: "fleeting", Realm: "pve", Password: password.Load().(string)}, nil //nolint:forcetypeassert
+	})
+
+	return &http.Client{Transport: transport}, transport, baseURL.JoinPath("/version").String()
+}
+
+func authTestGet(t *testing.T, client *http.Client, versionURL string) int {
+	t.Helper()
+
+	response, err := client.Get(versionURL) //nolint:noctx
+	require.NoError(t, err)
+	response.Body.Close()
+
+	return response.StatusCode
+}
+
+func Test_authTransport(t *testing.T) {
+	server := &fakeAuthServer{password: "first"}
+
+	password := &atomic.Value{}
+	password.Store("first")
+
+	client, _, versionURL := newAuthTestClient(t, server, password)
+
+	// Session is acquired on first request and reused
+	require.Equal(t, http.StatusOK, authTestGet(t, client, versionURL))
+	require.Equal(t, http.StatusOK, authTestGet(t, client, versionURL))
+	require.Equal(t, int32(1), server.ticketRequests.Load())
+
+	// Password rotation is picked up once by all concurrent requests
+	server.setPassword("second")
+	password.Store("second")
+
+	var wg sync.WaitGroup
+
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			require.Equal(t, http.StatusOK, authTestGet(t, client, versionURL))
+		}()
+	}
+
+	wg.Wait()
+
+	require.Equal(t, int32(2), server.ticketRequests.Load())
+
+	// Request fails when credentials are not valid anymore
+	server.setPassword("third")
+
+	_, err := client.Get(versionURL) //nolint:noctx
+	require.ErrorIs(t, err, ErrAuthenticationFailed)
+}
+
+func Test_authTransport_update(t *testing.T) {
+	server := &fakeAuthServer{password: "first"}
+
+	password := &atomic.Value{}
+	password.Store("first")
+
+	client, transport, versionURL := newAuthTestClient(t, server, password)
+	require.Equal(t, http.StatusOK, authTestGet(t, client, versionURL))
+
+	// Same credentials are not authenticated again
+	changed, err := transport.update(context.Background(), &Credentials{Username: "fleeting", Realm: "pve", Password: "first"})
+	require.NoError(t, err)
+	require.False(t, changed)
+	require.Equal(t, int32(1), server.ticketRequests.Load())
+
+	// Invalid credentials are rejected and previous ones are kept
+	_, err = transport.update(context.Background(), &Credentials{Username: "fleeting", Realm: "pve", Password: "wrong"})
+	require.ErrorIs(t, err, ErrAuthenticationFailed)
+	require.Equal(t, http.StatusOK, authTestGet(t, client, versionURL))
+
+	// Rotated credentials are used right away
+	server.setPassword("second")
+
+	changed, err = transport.update(context.Background(), &Credentials{Username: "fleeting", Realm: "pve", Password: "second"})
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, http.StatusOK, authTestGet(t, client, versionURL))
+}
+
+func Test_authTransport_apiToken(t *testing.T) {
+	var authorization atomic.Value
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(httpServer.Close)
+
+	baseURL, err := url.Parse(httpServer.URL + "/api2/json")
+	require.NoError(t, err)
+
+	transport := newAuthTransport(http.DefaultTransport, baseURL, func() (*Credentials, error) {
+		return &Credentials{TokenID: "fleeting@pve!plugin", TokenSecret: "c0ffee"}, nil
+	})
+	client := http.Client{Transport: transport}
+
+	response, err := client.Get(baseURL.JoinPath("/version").String()) //nolint:noctx
+	require.NoError(t, err)
+	response.Body.Close()
+
+	require.Equal(t, "PVEAPIToken=fleeting@pve!plugin=c0ffee", authorization.Load())
+}
+
+func Test_authTransport_ticket_tfa(t *testing.T) {
+	tests := []struct {
+		name           string
+		credentials    Credentials
+		partialTicket  map[string]any
+		expectedTicket string
+		expectedError  error
+	}{
+		{
+			name:           "Completed with one-time password",
+			credentials:    Credentials{Username: "fleeting", Realm: "pve", Password: "secret", Otp: "123456"},
+			partialTicket:  map[string]any{"ticket": "PVE:!tfa!partial", "NeedTFA": 1},
+			expectedTicket: "full",
+		},
+		{
+			name:          "Partial ticket without one-time password",
+			credentials:   Credentials{Username: "fleeting", Realm: "pve", Password: "secret"},
+			partialTicket: map[string]any{"ticket": "PVE:!tfa!partial", "NeedTFA": 1},
+			expectedError: ErrAuthenticationFailed,
+		},
+		{
+			name:          "Partial ticket without flag",
+			credentials:   Credentials{Username: "fleeting", Realm: "pve", Password: "secret"},
+			partialTicket: map[string]any{"ticket": "PVE:!tfa!partial"},
+			expectedError: ErrAuthenticationFailed,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := map[string]any{}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+
+				if body["tfa-challenge"] == nil {
+					_ = json.NewEncoder(w).Encode(map[string]any{"data": testCase.partialTicket})
+					return
+				}
+
+				if body["tfa-challenge"] != "PVE:!tfa!partial" || body["password"] != "totp:123456" || body["username"] != "fleeting" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				_ = json.NewEncoder(w).Encode(map[string]any{"data": proxmox.Session{Ticket: "full", CSRFPreventionToken: "csrf"}})
+			}))
+			t.Cleanup(server.Close)
+
+			baseURL, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			transport := newAuthTransport(http.DefaultTransport, baseURL, nil)
+
+			session, err := transport.ticket(context.Background(), &testCase.credentials)
+			if testCase.expectedError != nil {
+				require.ErrorIs(t, err, testCase.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedTicket, session.Ticket)
+		})
+	}
+}
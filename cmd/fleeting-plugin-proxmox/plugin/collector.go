@@ -49,7 +49,7 @@ func (ig *InstanceGroup) collectRemovedInstances() {
 
 	ig.instanceCloningMu.Lock()
 
-	pool, err := ig.getProxmoxPool(ctx)
+	members, err := ig.getPoolMembers(ctx)
 	if err != nil {
 		ig.log.Error("collector failed to list instances", "err", err)
 		ig.instanceCloningMu.Unlock()
@@ -59,10 +59,20 @@ func (ig *InstanceGroup) collectRemovedInstances() {
 
 	ig.instanceCloningMu.Unlock()
 
+	nodeStates, err := ig.getNodeStates(ctx)
+	if err != nil {
+		ig.log.Error("collector failed to get node states", "err", err)
+	}
+
+	ig.handleOrphanedInstances(ctx, members)
+	ig.drainMaintenanceNodes(ctx, members, nodeStates)
+	ig.replaceUnhealthyInstances(ctx, members)
+	ig.replaceInstancesWithUnknownPassword(ctx, members)
+
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
-	for _, member := range pool.Members {
+	for _, member := range members {
 		if !ig.isProxmoxResourceAnInstance(member) {
 			continue
 		}
@@ -71,6 +81,11 @@ func (ig *InstanceGroup) collectRemovedInstances() {
 			continue
 		}
 
+		if state, ok := nodeStates[member.Node]; ok && state != nodeStateOnline && state != nodeStateMaintenance {
+			ig.log.Warn("collector skipping instance on unavailable node", "vmid", member.VMID, "node", member.Node, "state", state)
+			continue
+		}
+
 		ig.log.Info("collector found instance to remove", "vmid", member.VMID, "name", member.Name)
 
 		wg.Add(1)
@@ -83,6 +98,10 @@ func (ig *InstanceGroup) collectRemovedInstances() {
 }
 
 func (ig *InstanceGroup) collectInstance(ctx context.Context, member proxmox.ClusterResource) {
+	// Every instance gets its own timeout, so a long pre-removal command doesn't leave no time to stop and delete it
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Duration(ig.Settings.PreRemovalExecTimeout)+collectionTimeout)
+	defer cancel()
+
 	vm, err := ig.getProxmoxVMOnNode(ctx, int(member.VMID), member.Node)
 	if err != nil {
 		ig.log.Error("collector failed to fetch instance info", "vmid", member.VMID, "err", err)
@@ -90,9 +109,11 @@ func (ig *InstanceGroup) collectInstance(ctx context.Context, member proxmox.Clu
 	}
 
 	if vm.Status == "running" {
+		ig.runPreRemovalExec(ctx, vm)
+
 		task, err := vm.Stop(ctx)
 		if err == nil {
-			err = task.Wait(ctx, proxmoxTaskWaitInterval, collectionTimeout)
+			err = waitForTask(ctx, task, collectionTimeout)
 		}
 
 		if err != nil {
@@ -103,12 +124,49 @@ func (ig *InstanceGroup) collectInstance(ctx context.Context, member proxmox.Clu
 
 	task, err := vm.Delete(ctx)
 	if err == nil {
-		err = task.Wait(ctx, proxmoxTaskWaitInterval, collectionTimeout)
+		err = waitForTask(ctx, task, collectionTimeout)
 	}
 
+	ig.invalidateInventory()
+
 	if err != nil {
 		ig.log.Error("collector failed to delete instance", "vmid", member.VMID, "err", err)
+		return
+	}
+
+	ig.journalRemove(int(member.VMID))
+}
+
+// Runs configured pre-removal command inside the instance. Failures are only logged as they must not block the removal.
+func (ig *InstanceGroup) runPreRemovalExec(ctx context.Context, vm *proxmox.VirtualMachine) {
+	if len(ig.Settings.PreRemovalExec) < 1 {
+		return
 	}
+
+	log := ig.log.With("vmid", vm.VMID, "command", ig.Settings.PreRemovalExec)
+	log.Info("collector running pre-removal command")
+
+	status, err := ig.agentExec(ctx, vm, ig.Settings.PreRemovalExec, time.Duration(ig.Settings.PreRemovalExecTimeout))
+	if err != nil {
+		log.Error("collector failed to run pre-removal command", "err", err)
+		return
+	}
+
+	log = log.With(
+		"exitcode", status.ExitCode,
+		"signal", status.Signal,
+		"stdout", status.OutData,
+		"stdout_truncated", status.OutTruncated,
+		"stderr", status.ErrData,
+		"stderr_truncated", status.ErrTruncated,
+	)
+
+	if !status.succeeded() {
+		log.Warn("collector pre-removal command exited with non-zero code or was killed by a signal")
+		return
+	}
+
+	log.Info("collector finished pre-removal command")
 }
 
 func (ig *InstanceGroup) drainInstanceCollectionTriggerChannel() {
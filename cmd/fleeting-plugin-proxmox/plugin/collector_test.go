@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceGroup_collectInstance_collectionTimedOut(t *testing.T) {
+	client, requests := newRecordingServer(t, map[string]string{
+		"/nodes/pve1/status":                  `{}`,
+		"/nodes/pve1/qemu/100/status/current": `{"vmid":100,"status":"running"}`,
+		"/nodes/pve1/qemu/100/config":         `{}`,
+	})
+
+	ig := InstanceGroup{
+		log:      hclog.NewNullLogger(),
+		proxmox:  client,
+		Settings: Settings{PreRemovalExecTimeout: Duration(time.Minute)},
+	}
+
+	// Collection deadline passed while pre-removal command of this or another instance was running
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	ig.collectInstance(ctx, proxmox.ClusterResource{Type: "qemu", Node: "pve1", VMID: 100})
+
+	require.Equal(t, []recordedRequest{
+		{Method: http.MethodPost, Path: "/nodes/pve1/qemu/100/status/stop", Body: map[string]any{}},
+		{Method: http.MethodDelete, Path: "/nodes/pve1/qemu/100", Body: map[string]any{}},
+	}, requests())
+}
@@ -0,0 +1,255 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/luthermonson/go-proxmox"
+	"gitlab.com/gitlab-org/fleeting/fleeting/provider"
+)
+
+const (
+	// Built-in administrator account of Windows.
+	windowsAdministrator = "Administrator"
+
+	// Length of generated instance passwords.
+	instancePasswordLength = 24
+)
+
+var ErrInstancePasswordUnknown = errors.New("generated password of instance deployed before plugin restart is unknown")
+
+// Character classes of generated passwords, one of each is always included to satisfy Windows complexity requirements.
+var passwordCharacterClasses = []string{
+	"ABCDEFGHJKLMNPQRSTUVWXYZ",
+	"abcdefghijkmnopqrstuvwxyz",
+	"23456789",
+	"-_.!@#%^*+=",
+}
+
+// Returns connector config recorded when the instance was deployed, or detects it for instances deployed before restart.
+// Generated passwords are never set again, as the instance might be in use, so such instances can't be connected to.
+func (ig *InstanceGroup) getInstanceConnectorConfig(ctx context.Context, node string, vmid uint64) (provider.ConnectorConfig, error) {
+	if config, ok := ig.recordedInstanceConnectorConfig(vmid); ok {
+		return config, nil
+	}
+
+	config := ig.detectInstanceConnectorConfig(ctx, node, vmid)
+
+	if ig.instancePasswordUsername(&config) != "" {
+		return provider.ConnectorConfig{}, fmt.Errorf("%w: vmid='%d'", ErrInstancePasswordUnknown, vmid)
+	}
+
+	ig.recordInstanceConnectorConfig(vmid, config)
+
+	return config, nil
+}
+
+// Prepares connector config of newly deployed or adopted instance, sets generated password if enabled, and records it.
+func (ig *InstanceGroup) configureInstanceConnector(ctx context.Context, vm *proxmox.VirtualMachine) error {
+	vmid := uint64(vm.VMID)
+
+	// Config of reused VMID must not be returned for the new instance
+	ig.forgetInstanceConnectorConfig(vmid)
+
+	config := ig.detectInstanceConnectorConfig(ctx, vm.Node, vmid)
+
+	if username := ig.instancePasswordUsername(&config); username != "" {
+		password, err := generatePassword(instancePasswordLength)
+		if err != nil {
+			return err
+		}
+
+		if err := ig.agentSetUserPassword(ctx, vm.Node, vmid, username, password); err != nil {
+			return err
+		}
+
+		config.Username = username
+		config.Password = password
+	}
+
+	ig.recordInstanceConnectorConfig(vmid, config)
+
+	return nil
+}
+
+// Marks running instances which generated password is unknown for removal, e.g. instances deployed before restart.
+func (ig *InstanceGroup) replaceInstancesWithUnknownPassword(ctx context.Context, members []proxmox.ClusterResource) {
+	if !ig.Settings.InstancePassword && !ig.Settings.WindowsAdministratorPassword {
+		return
+	}
+
+	unknown := []*proxmox.ClusterResource{}
+
+	for _, member := range members {
+		member := member
+
+		if !ig.isProxmoxResourceAnInstance(member) || member.Name != ig.Settings.InstanceNameRunning || member.Status != "running" {
+			continue
+		}
+
+		if _, err := ig.getInstanceConnectorConfig(ctx, member.Node, member.VMID); !errors.Is(err, ErrInstancePasswordUnknown) {
+			continue
+		}
+
+		ig.log.Info("password of running instance is unknown, marking for removal", "vmid", member.VMID, "node", member.Node)
+		unknown = append(unknown, &member)
+	}
+
+	if len(unknown) < 1 {
+		return
+	}
+
+	if err := ig.markInstancesForRemoval(ctx, unknown...); err != nil {
+		ig.log.Error("failed to mark instances with unknown password for removal", "err", err)
+	}
+}
+
+// Fills OS, architecture and protocol missing in connector config from instance's OS.
+func (ig *InstanceGroup) detectInstanceConnectorConfig(ctx context.Context, node string, vmid uint64) provider.ConnectorConfig {
+	config := ig.FleetingSettings.ConnectorConfig
+
+	if config.OS != "" && config.Arch != "" && config.Protocol != "" {
+		return config
+	}
+
+	osInfo, err := ig.agentGetOSInfo(ctx, node, vmid)
+	if err != nil {
+		// Older agents don't support get-osinfo, static connector config is used then
+		ig.log.Warn("failed to detect instance os, using connector config", "vmid", vmid, "err", err)
+		return config
+	}
+
+	fillConnectorConfigFromOSInfo(&config, osInfo)
+
+	return config
+}
+
+// Returns user whose password should be generated for the instance, empty if none.
+func (ig *InstanceGroup) instancePasswordUsername(config *provider.ConnectorConfig) string {
+	if ig.Settings.WindowsAdministratorPassword && config.OS == "windows" {
+		return windowsAdministrator
+	}
+
+	if ig.Settings.InstancePassword {
+		return config.Username
+	}
+
+	return ""
+}
+
+func (ig *InstanceGroup) recordedInstanceConnectorConfig(vmid uint64) (provider.ConnectorConfig, bool) {
+	ig.instanceConnectorConfigsMu.Lock()
+	defer ig.instanceConnectorConfigsMu.Unlock()
+
+	config, ok := ig.instanceConnectorConfigs[vmid]
+
+	return config, ok
+}
+
+func (ig *InstanceGroup) recordInstanceConnectorConfig(vmid uint64, config provider.ConnectorConfig) {
+	ig.instanceConnectorConfigsMu.Lock()
+	defer ig.instanceConnectorConfigsMu.Unlock()
+
+	if ig.instanceConnectorConfigs == nil {
+		ig.instanceConnectorConfigs = map[uint64]provider.ConnectorConfig{}
+	}
+
+	ig.instanceConnectorConfigs[vmid] = config
+}
+
+func (ig *InstanceGroup) forgetInstanceConnectorConfig(vmid uint64) {
+	ig.instanceConnectorConfigsMu.Lock()
+	defer ig.instanceConnectorConfigsMu.Unlock()
+
+	delete(ig.instanceConnectorConfigs, vmid)
+}
+
+// Fills OS, architecture and protocol which are not set in connector config from OS info reported by QEMU guest agent.
+func fillConnectorConfigFromOSInfo(config *provider.ConnectorConfig, osInfo *proxmox.AgentOsInfo) {
+	if config.OS == "" {
+		config.OS = osFromOSInfo(osInfo)
+	}
+
+	if config.Arch == "" {
+		config.Arch = archFromMachine(osInfo.Machine)
+	}
+
+	if config.Protocol == "" && config.OS != "" {
+		config.Protocol = provider.ProtocolSSH
+
+		if config.OS == "windows" {
+			config.Protocol = provider.ProtocolWinRM
+		}
+	}
+}
+
+// Returns GOOS-like name of the OS, e.g. windows or linux.
+func osFromOSInfo(osInfo *proxmox.AgentOsInfo) string {
+	switch strings.ToLower(osInfo.ID) {
+	case "":
+		return ""
+	case "mswindows":
+		return "windows"
+	case "freebsd", "netbsd", "openbsd":
+		return strings.ToLower(osInfo.ID)
+	default:
+		// Linux distributions report their own ID, e.g. ubuntu or debian
+		return "linux"
+	}
+}
+
+// Returns GOARCH-like name of the architecture, e.g. amd64 or arm64.
+func archFromMachine(machine string) string {
+	switch strings.ToLower(machine) {
+	case "x86_64", "amd64":
+		return "amd64"
+	case "i386", "i486", "i586", "i686", "x86":
+		return "386"
+	case "aarch64", "arm64":
+		return "arm64"
+	case "":
+		return ""
+	default:
+		if strings.HasPrefix(strings.ToLower(machine), "arm") {
+			return "arm"
+		}
+
+		return strings.ToLower(machine)
+	}
+}
+
+// Generates random password of given length with at least one character of every class.
+func generatePassword(length int) (string, error) {
+	alphabet := strings.Join(passwordCharacterClasses, "")
+
+	for {
+		password := make([]byte, length)
+
+		for i := range password {
+			index, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+			if err != nil {
+				return "", fmt.Errorf("failed to generate password: %w", err)
+			}
+
+			password[i] = alphabet[index.Int64()]
+		}
+
+		if hasEveryCharacterClass(string(password)) {
+			return string(password), nil
+		}
+	}
+}
+
+func hasEveryCharacterClass(password string) bool {
+	for _, class := range passwordCharacterClasses {
+		if !strings.ContainsAny(password, class) {
+			return false
+		}
+	}
+
+	return true
+}
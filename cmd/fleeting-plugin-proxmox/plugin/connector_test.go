@@ -0,0 +1,242 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/fleeting/fleeting/provider"
+)
+
+func Test_fillConnectorConfigFromOSInfo(t *testing.T) {
+	tests := []struct {
+		name string
+
+		config provider.ConnectorConfig
+		osInfo proxmox.AgentOsInfo
+
+		expected provider.ConnectorConfig
+	}{
+		{
+			name:     "Windows",
+			osInfo:   proxmox.AgentOsInfo{ID: "mswindows", Machine: "x86_64"},
+			expected: provider.ConnectorConfig{OS: "windows", Arch: "amd64", Protocol: provider.ProtocolWinRM},
+		},
+		{
+			name:     "Linux",
+			osInfo:   proxmox.AgentOsInfo{ID: "ubuntu", Machine: "aarch64"},
+			expected: provider.ConnectorConfig{OS: "linux", Arch: "arm64", Protocol: provider.ProtocolSSH},
+		},
+		{
+			name:     "FreeBSD",
+			osInfo:   proxmox.AgentOsInfo{ID: "freebsd", Machine: "amd64"},
+			expected: provider.ConnectorConfig{OS: "freebsd", Arch: "amd64", Protocol: provider.ProtocolSSH},
+		},
+		{
+			name:     "Configured values are kept",
+			config:   provider.ConnectorConfig{OS: "windows", Protocol: provider.ProtocolSSH, Username: "runner"},
+			osInfo:   proxmox.AgentOsInfo{ID: "mswindows", Machine: "i686"},
+			expected: provider.ConnectorConfig{OS: "windows", Arch: "386", Protocol: provider.ProtocolSSH, Username: "runner"},
+		},
+		{
+			name:     "Unknown OS",
+			osInfo:   proxmox.AgentOsInfo{},
+			expected: provider.ConnectorConfig{},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			config := testCase.config
+			fillConnectorConfigFromOSInfo(&config, &testCase.osInfo)
+			require.Equal(t, testCase.expected, config)
+		})
+	}
+}
+
+func Test_generatePassword(t *testing.T) {
+	passwords := map[string]bool{}
+
+	for range 100 {
+		password, err := generatePassword(instancePasswordLength)
+		require.NoError(t, err)
+		require.Len(t, password, instancePasswordLength)
+		require.True(t, hasEveryCharacterClass(password))
+
+		passwords[password] = true
+	}
+
+	require.Len(t, passwords, 100)
+}
+
+func TestInstanceGroup_configureInstanceConnector(t *testing.T) {
+	client, requests := newRecordingServer(t, map[string]string{
+		"/nodes/pve1/qemu/100/agent/get-osinfo": `{"result":{"id":"mswindows","machine":"x86_64"}}`,
+	})
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+		Settings: Settings{
+			WindowsAdministratorPassword: true,
+		},
+		FleetingSettings: provider.Settings{
+			ConnectorConfig: provider.ConnectorConfig{Username: "runner"},
+		},
+	}
+
+	vm := &proxmox.VirtualMachine{Node: "pve1", VMID: 100}
+
+	require.NoError(t, ig.configureInstanceConnector(context.Background(), vm))
+
+	config, err := ig.getInstanceConnectorConfig(context.Background(), "pve1", 100)
+	require.NoError(t, err)
+	require.Equal(t, "windows", config.OS)
+	require.Equal(t, "amd64", config.Arch)
+	require.Equal(t, provider.ProtocolWinRM, config.Protocol)
+	require.Equal(t, "Administrator", config.Username)
+	require.Len(t, config.Password, instancePasswordLength)
+
+	require.Equal(t, []recordedRequest{
+		{
+			Method: "POST",
+			Path:   "/nodes/pve1/qemu/100/agent/set-user-password",
+			Body:   map[string]any{"username": "Administrator", "password": config.Password},
+		},
+	}, requests())
+
+	// Each instance gets its own password
+	require.NoError(t, ig.configureInstanceConnector(context.Background(), vm))
+
+	regenerated, err := ig.getInstanceConnectorConfig(context.Background(), "pve1", 100)
+	require.NoError(t, err)
+	require.NotEqual(t, config.Password, regenerated.Password)
+	require.Len(t, requests(), 2)
+}
+
+func TestInstanceGroup_getInstanceConnectorConfig_unknownPassword(t *testing.T) {
+	client, requests := newRecordingServer(t, map[string]string{
+		"/nodes/pve1/qemu/100/agent/get-osinfo": `{"result":{"id":"mswindows","machine":"x86_64"}}`,
+		"/nodes/pve1/qemu/101/agent/get-osinfo": `{"result":{"id":"debian","machine":"x86_64"}}`,
+	})
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+		Settings: Settings{
+			WindowsAdministratorPassword: true,
+		},
+		FleetingSettings: provider.Settings{
+			ConnectorConfig: provider.ConnectorConfig{Username: "runner", Password: "static"},
+		},
+	}
+
+	// Password of instance deployed before restart is not set again, as the instance might be in use
+	_, err := ig.getInstanceConnectorConfig(context.Background(), "pve1", 100)
+	require.ErrorIs(t, err, ErrInstancePasswordUnknown)
+
+	// Instances without generated password use detected connector config
+	config, err := ig.getInstanceConnectorConfig(context.Background(), "pve1", 101)
+	require.NoError(t, err)
+	require.Equal(t, provider.ConnectorConfig{OS: "linux", Arch: "amd64", Protocol: provider.ProtocolSSH, Username: "runner", Password: "static"}, config)
+
+	require.Empty(t, requests())
+}
+
+func TestInstanceGroup_replaceInstancesWithUnknownPassword(t *testing.T) {
+	client, requests := newRecordingServer(t, map[string]string{
+		"/nodes/pve1/status":                  `{}`,
+		"/nodes/pve1/qemu/101/status/current": `{"vmid":101,"status":"running"}`,
+		"/nodes/pve1/qemu/101/config":         `{}`,
+	})
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+		Settings: Settings{
+			TemplateID:       &sampleTemplateID,
+			InstancePassword: true,
+		},
+		FleetingSettings: provider.Settings{
+			ConnectorConfig: provider.ConnectorConfig{OS: "linux", Arch: "amd64", Protocol: provider.ProtocolSSH, Username: "runner"},
+		},
+		instanceConnectorConfigs: map[uint64]provider.ConnectorConfig{
+			100: {Username: "runner", Password: "generated"},
+		},
+		instanceCollectionTrigger: make(chan struct{}, triggerChannelCapacity),
+	}
+	ig.Settings.FillWithDefaults()
+
+	ig.replaceInstancesWithUnknownPassword(context.Background(), []proxmox.ClusterResource{
+		{Type: "qemu", Node: "pve1", VMID: 100, Name: "fleeting-running", Status: "running"},
+		{Type: "qemu", Node: "pve1", VMID: 101, Name: "fleeting-running", Status: "running"},
+		{Type: "qemu", Node: "pve1", VMID: 102, Name: "fleeting-creating", Status: "running"},
+	})
+
+	// Only the instance deployed before restart is replaced
+	require.Equal(t, []recordedRequest{
+		{Method: "POST", Path: "/nodes/pve1/qemu/101/config", Body: map[string]any{"name": "fleeting-removing"}},
+	}, requests())
+}
+
+func TestInstanceGroup_getInstanceConnectorConfig_withoutOSInfo(t *testing.T) {
+	// Agent without get-osinfo support
+	client, requests := newRecordingServer(t, map[string]string{})
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+		Settings: Settings{
+			WindowsAdministratorPassword: true,
+		},
+		FleetingSettings: provider.Settings{
+			ConnectorConfig: provider.ConnectorConfig{Username: "runner", Password: "static"},
+		},
+	}
+
+	config, err := ig.getInstanceConnectorConfig(context.Background(), "pve1", 100)
+	require.NoError(t, err)
+	require.Equal(t, provider.ConnectorConfig{Username: "runner", Password: "static"}, config)
+	require.Empty(t, requests())
+}
+
+func TestInstanceGroup_getInstanceConnectorConfig_instancePassword(t *testing.T) {
+	client, requests := newRecordingServer(t, map[string]string{
+		"/nodes/pve1/qemu/100/agent/get-osinfo": `{"result":{"id":"debian","machine":"x86_64"}}`,
+	})
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+		Settings: Settings{
+			InstancePassword:             true,
+			WindowsAdministratorPassword: true,
+		},
+		FleetingSettings: provider.Settings{
+			ConnectorConfig: provider.ConnectorConfig{Username: "runner", Password: "shared"},
+		},
+	}
+
+	require.NoError(t, ig.configureInstanceConnector(context.Background(), &proxmox.VirtualMachine{Node: "pve1", VMID: 100}))
+
+	config, err := ig.getInstanceConnectorConfig(context.Background(), "pve1", 100)
+	require.NoError(t, err)
+	require.Equal(t, "linux", config.OS)
+	require.Equal(t, provider.ProtocolSSH, config.Protocol)
+	require.Equal(t, "runner", config.Username)
+	require.NotEqual(t, "shared", config.Password)
+	require.Len(t, config.Password, instancePasswordLength)
+
+	require.Equal(t, []recordedRequest{
+		{
+			Method: "POST",
+			Path:   "/nodes/pve1/qemu/100/agent/set-user-password",
+			Body:   map[string]any{"username": "runner", "password": config.Password},
+		},
+	}, requests())
+
+	// Shared connector config is not modified
+	require.Equal(t, "shared", ig.FleetingSettings.ConnectorConfig.Password)
+}
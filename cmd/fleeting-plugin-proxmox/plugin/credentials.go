@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// Prefix of environment variables read when credentials_from_env is enabled.
+const credentialsEnvPrefix = "PROXMOX_"
+
+// Placeholder of secrets in logs.
+const redacted = "<redacted>"
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Proxmox VE credentials, either user's password or API token.
+type Credentials struct {
+	Realm    string `json:"realm,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Otp      string `json:"otp,omitempty"`
+
+	// Base32 encoded TOTP secret, fresh one-time password is generated from it for every new session.
+	TOTPSecret string `json:"totp_secret,omitempty"`
+
+	Path  string `json:"path,omitempty"`
+	Privs string `json:"privs,omitempty"`
+
+	// API token ID in format "user@realm!token", used instead of username and password.
+	TokenID     string `json:"token_id,omitempty"`
+	TokenSecret string `json:"token_secret,omitempty"`
+
+	// Paths to files with values of the fields above.
+	RealmFile       string `json:"realm_file,omitempty"`
+	UsernameFile    string `json:"username_file,omitempty"`
+	PasswordFile    string `json:"password_file,omitempty"`
+	OtpFile         string `json:"otp_file,omitempty"`
+	TOTPSecretFile  string `json:"totp_secret_file,omitempty"`
+	TokenIDFile     string `json:"token_id_file,omitempty"`
+	TokenSecretFile string `json:"token_secret_file,omitempty"`
+}
+
+// Field of credentials which can be read from environment variable or file.
+type credentialsField struct {
+	name  string
+	value *string
+	file  *string
+}
+
+func (c *Credentials) fields() []credentialsField {
+	return []credentialsField{
+		{name: "realm", value: &c.Realm, file: &c.RealmFile},
+		{name: "username", value: &c.Username, file: &c.UsernameFile},
+		{name: "password", value: &c.Password, file: &c.PasswordFile},
+		{name: "otp", value: &c.Otp, file: &c.OtpFile},
+		{name: "totp_secret", value: &c.TOTPSecret, file: &c.TOTPSecretFile},
+		{name: "token_id", value: &c.TokenID, file: &c.TokenIDFile},
+		{name: "token_secret", value: &c.TokenSecret, file: &c.TokenSecretFile},
+	}
+}
+
+// Resolves every field from the first source which sets it:
+// PROXMOX_<FIELD> and PROXMOX_<FIELD>_FILE environment variables (if enabled), then <field> and <field>_file.
+func (c *Credentials) resolve(fromEnv bool) error {
+	for _, field := range c.fields() {
+		value, file := *field.value, *field.file
+
+		if fromEnv {
+			envName := credentialsEnvPrefix + strings.ToUpper(field.name)
+
+			if envValue := os.Getenv(envName); envValue != "" {
+				value, file = envValue, ""
+			} else if envFile := os.Getenv(envName + "_FILE"); envFile != "" {
+				value, file = "", envFile
+			}
+		}
+
+		if value == "" && file != "" {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read credentials field='%s' from path='%s': %w", field.name, file, err)
+			}
+
+			value = strings.TrimRight(string(content), "\r\n")
+		}
+
+		*field.value = value
+		*field.file = ""
+	}
+
+	return nil
+}
+
+// Checks that credentials contain either username and password or API token.
+func (c *Credentials) validate() error {
+	if c.IsAPIToken() {
+		if c.TokenSecret == "" {
+			return fmt.Errorf("%w: token_secret is required with token_id", ErrInvalidCredentials)
+		}
+
+		return nil
+	}
+
+	if c.Username == "" || c.Password == "" {
+		return fmt.Errorf("%w: either username and password or token_id and token_secret are required", ErrInvalidCredentials)
+	}
+
+	if c.TOTPSecret != "" {
+		if c.Otp != "" {
+			return fmt.Errorf("%w: otp can't be used with totp_secret", ErrInvalidCredentials)
+		}
+
+		if _, err := parseTOTPSecret(c.TOTPSecret); err != nil {
+			return fmt.Errorf("%w: totp_secret: %w", ErrInvalidCredentials, err)
+		}
+	}
+
+	return nil
+}
+
+// Checks if credentials are API token rather than user's password.
+func (c *Credentials) IsAPIToken() bool {
+	return c.TokenID != ""
+}
+
+// Returns credentials used to acquire session ticket, with one-time password generated for given time if TOTP secret is set.
+func (c *Credentials) proxmoxCredentials(now time.Time) (*proxmox.Credentials, error) {
+	otp := c.Otp
+
+	if c.TOTPSecret != "" {
+		var err error
+
+		otp, err = generateTOTP(c.TOTPSecret, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &proxmox.Credentials{
+		Username: c.Username,
+		Password: c.Password,
+		Otp:      otp,
+		Path:     c.Path,
+		Privs:    c.Privs,
+		Realm:    c.Realm,
+	}, nil
+}
+
+// String implements fmt.Stringer, secrets are redacted so credentials can be safely logged.
+func (c Credentials) String() string {
+	return fmt.Sprintf("realm='%s' username='%s' password='%s' otp='%s' totp_secret='%s' token_id='%s' token_secret='%s'",
+		c.Realm, c.Username, redact(c.Password), redact(c.Otp), redact(c.TOTPSecret), c.TokenID, redact(c.TokenSecret))
+}
+
+// GoString implements fmt.GoStringer, so secrets are redacted with %#v as well.
+func (c Credentials) GoString() string {
+	return "Credentials{" + c.String() + "}"
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	return redacted
+}
@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentials_resolve(t *testing.T) {
+	tempDir := t.TempDir()
+
+	passwordFile := path.Join(tempDir, "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("file-password\n"), 0o600))
+
+	tokenSecretFile := path.Join(tempDir, "token_secret")
+	require.NoError(t, os.WriteFile(tokenSecretFile, []byte("file-token-secret"), 0o600))
+
+	t.Setenv("PROXMOX_USERNAME", "env-username")
+	t.Setenv("PROXMOX_PASSWORD_FILE", passwordFile)
+	t.Setenv("PROXMOX_TOKEN_SECRET", "env-token-secret")
+	t.Setenv("PROXMOX_TOKEN_SECRET_FILE", tokenSecretFile)
+
+	newCredentials := func() Credentials {
+		return Credentials{
+			Realm:        "pve",
+			Username:     "json-username",
+			Password:     "json-password",
+			PasswordFile: path.Join(tempDir, "missing"),
+			OtpFile:      passwordFile,
+		}
+	}
+
+	// Environment is ignored unless enabled
+	credentials := newCredentials()
+	require.NoError(t, credentials.resolve(false))
+	require.Equal(t, "pve", credentials.Realm)
+	require.Equal(t, "json-username", credentials.Username)
+	require.Equal(t, "json-password", credentials.Password)
+	require.Equal(t, "file-password", credentials.Otp)
+	require.Empty(t, credentials.TokenSecret)
+	require.Empty(t, credentials.PasswordFile)
+
+	// Environment variables take precedence over credentials file, values over files
+	credentials = newCredentials()
+	require.NoError(t, credentials.resolve(true))
+	require.Equal(t, "pve", credentials.Realm)
+	require.Equal(t, "env-username", credentials.Username)
+	require.Equal(t, "file-password", credentials.Password)
+	require.Equal(t, "env-token-secret", credentials.TokenSecret)
+
+	// Missing file
+	credentials = Credentials{PasswordFile: path.Join(tempDir, "missing")}
+	require.ErrorIs(t, credentials.resolve(false), os.ErrNotExist)
+}
+
+func TestCredentials_validate(t *testing.T) {
+	tests := []struct {
+		name          string
+		credentials   Credentials
+		expectedError error
+	}{
+		{
+			name:          "Password",
+			credentials:   Credentials{Username: "root", Password: "secret"},
+			expectedError: nil,
+		},
+		{
+			name:          "API token",
+			credentials:   Credentials{TokenID: "root@pam!fleeting", TokenSecret: "secret"},
+			expectedError: nil,
+		},
+		{
+			name:          "Missing password",
+			credentials:   Credentials{Username: "root"},
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "Missing token secret",
+			credentials:   Credentials{TokenID: "root@pam!fleeting", Password: "secret"},
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "TOTP secret",
+			credentials:   Credentials{Username: "root", Password: "secret", TOTPSecret: "GEZDGNBVGY3TQOJQ"},
+			expectedError: nil,
+		},
+		{
+			name:          "Invalid TOTP secret",
+			credentials:   Credentials{Username: "root", Password: "secret", TOTPSecret: "not base32!"},
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "Both OTP and TOTP secret",
+			credentials:   Credentials{Username: "root", Password: "secret", Otp: "123456", TOTPSecret: "GEZDGNBVGY3TQOJQ"},
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "Empty",
+			credentials:   Credentials{},
+			expectedError: ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorIs(t, tt.credentials.validate(), tt.expectedError)
+		})
+	}
+}
+
+func TestCredentials_proxmoxCredentials(t *testing.T) {
+	// Static one-time password
+	credentials := Credentials{Realm: "pve", Username: "root", Password: "secret", Otp: "123456"}
+
+	proxmoxCredentials, err := credentials.proxmoxCredentials(time.Unix(59, 0))
+	require.NoError(t, err)
+	require.Equal(t, "123456", proxmoxCredentials.Otp)
+	require.Equal(t, "root", proxmoxCredentials.Username)
+
+	// Fresh one-time password for every call
+	credentials = Credentials{Realm: "pve", Username: "root", Password: "secret", TOTPSecret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"}
+
+	proxmoxCredentials, err = credentials.proxmoxCredentials(time.Unix(59, 0))
+	require.NoError(t, err)
+	require.Equal(t, "287082", proxmoxCredentials.Otp)
+
+	proxmoxCredentials, err = credentials.proxmoxCredentials(time.Unix(1111111109, 0))
+	require.NoError(t, err)
+	require.Equal(t, "081804", proxmoxCredentials.Otp)
+}
+
+func TestCredentials_String(t *testing.T) {
+	credentials := Credentials{Realm: "pve", Username: "root", Password: "password-secret", TOTPSecret: "totp-secret", TokenSecret: "token-secret"}
+
+	for _, formatted := range []string{fmt.Sprint(credentials), fmt.Sprintf("%v", &credentials), fmt.Sprintf("%#v", credentials)} {
+		require.Contains(t, formatted, "username='root'")
+		require.Contains(t, formatted, redacted)
+		require.NotContains(t, formatted, "password-secret")
+		require.NotContains(t, formatted, "token-secret")
+		require.NotContains(t, formatted, "totp-secret")
+	}
+}
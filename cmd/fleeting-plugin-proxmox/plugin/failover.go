@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// How long failed Proxmox VE API endpoint is tried only after all other endpoints.
+const endpointRetryAfter = 30 * time.Second
+
+// Proxmox VE API endpoint with its health.
+type apiEndpoint struct {
+	url      *url.URL
+	failedAt time.Time
+}
+
+// HTTP transport sending requests to the active Proxmox VE API endpoint and failing over to other endpoints when it fails.
+type failoverTransport struct {
+	transport    http.RoundTripper
+	log          hclog.Logger
+	onSwitchover func(endpoint *url.URL)
+
+	mu        sync.Mutex
+	endpoints []*apiEndpoint
+	active    int
+}
+
+// Creates failover transport, requests must be made to the first endpoint and are rewritten to the active one.
+func newFailoverTransport(transport http.RoundTripper, log hclog.Logger, urls []*url.URL, onSwitchover func(endpoint *url.URL)) *failoverTransport {
+	endpoints := make([]*apiEndpoint, 0, len(urls))
+	for _, endpointURL := range urls {
+		// Joined URLs without path don't start with slash, so they are normalized to compare paths later
+		normalized := *endpointURL
+		normalized.Path = "/" + strings.TrimPrefix(normalized.Path, "/")
+
+		endpoints = append(endpoints, &apiEndpoint{url: &normalized})
+	}
+
+	return &failoverTransport{
+		transport:    transport,
+		log:          log,
+		onSwitchover: onSwitchover,
+		endpoints:    endpoints,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *failoverTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	candidates := t.candidates()
+
+	for _, index := range candidates[:len(candidates)-1] {
+		response, err := t.roundTripTo(request, index)
+
+		if (err == nil && !isEndpointFailure(response)) || !canRetryRequest(request, err) || request.Context().Err() != nil {
+			return response, err
+		}
+
+		t.log.Warn("proxmox api endpoint failed, trying next one", "endpoint", t.endpoints[index].url.Host, "err", err, "status", responseStatus(response))
+
+		if response != nil {
+			_, _ = io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+	}
+
+	return t.roundTripTo(request, candidates[len(candidates)-1])
+}
+
+// Sends the request to given endpoint and records endpoint's health.
+func (t *failoverTransport) roundTripTo(request *http.Request, index int) (*http.Response, error) {
+	endpointRequest, err := t.rewriteRequest(request, t.endpoints[index].url)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.transport.RoundTrip(endpointRequest)
+
+	switch {
+	case err == nil && !isEndpointFailure(response):
+		t.markHealthy(index)
+	case request.Context().Err() == nil:
+		t.markFailed(index)
+	}
+
+	return response, err //nolint:wrapcheck
+}
+
+// Returns endpoint indexes in order they should be tried, the active and healthy ones go first.
+func (t *failoverTransport) candidates() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	healthy := make([]int, 0, len(t.endpoints))
+	failed := make([]int, 0, len(t.endpoints))
+
+	for offset := range t.endpoints {
+		index := (t.active + offset) % len(t.endpoints)
+
+		if failedAt := t.endpoints[index].failedAt; !failedAt.IsZero() && time.Since(failedAt) < endpointRetryAfter {
+			failed = append(failed, index)
+		} else {
+			healthy = append(healthy, index)
+		}
+	}
+
+	return append(healthy, failed...)
+}
+
+// Marks endpoint as healthy and, if it was not the active one, switches over to it.
+func (t *failoverTransport) markHealthy(index int) {
+	t.mu.Lock()
+
+	t.endpoints[index].failedAt = time.Time{}
+
+	switched := t.active != index
+	t.active = index
+
+	t.mu.Unlock()
+
+	if switched {
+		t.log.Warn("switched over to another proxmox api endpoint", "endpoint", t.endpoints[index].url.Host)
+
+		if t.onSwitchover != nil {
+			t.onSwitchover(t.endpoints[index].url)
+		}
+	}
+}
+
+// Marks endpoint as failed.
+func (t *failoverTransport) markFailed(index int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.endpoints[index].failedAt = time.Now()
+}
+
+// Returns copy of the request pointed to given endpoint.
+func (t *failoverTransport) rewriteRequest(request *http.Request, endpoint *url.URL) (*http.Request, error) {
+	base := t.endpoints[0].url
+
+	rewritten := request.Clone(request.Context())
+	rewritten.URL.Scheme = endpoint.Scheme
+	rewritten.URL.Host = endpoint.Host
+
+	// Escaped path is kept, so escaped segments (e.g. CIDR in IP set entry) are not split
+	rawPath := endpoint.EscapedPath() + strings.TrimPrefix("/"+strings.TrimPrefix(request.URL.EscapedPath(), "/"), base.EscapedPath())
+
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewrite request path='%s': %w", rawPath, err)
+	}
+
+	rewritten.URL.Path = path
+	rewritten.URL.RawPath = rawPath
+	rewritten.Host = ""
+
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		rewritten.Body = body
+	}
+
+	return rewritten, nil
+}
+
+// Checks if response means that the endpoint itself is unavailable rather than the request failed.
+func isEndpointFailure(response *http.Response) bool {
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusInternalServerError:
+		// Node which lost quorum can't serve most of the API calls
+		return strings.Contains(response.Status, "no quorum")
+	default:
+		return false
+	}
+}
+
+// Checks if failed request can be safely sent to another endpoint.
+func canRetryRequest(request *http.Request, err error) bool {
+	if request.Body != nil && request.GetBody == nil {
+		return false
+	}
+
+	if err == nil || request.Method == http.MethodGet {
+		return true
+	}
+
+	// Other requests might have been already processed, unless connection was never established
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Returns status of the response if there is any.
+func responseStatus(response *http.Response) string {
+	if response == nil {
+		return ""
+	}
+
+	return response.Status
+}
@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+// Starts test server responding with given status and counting received requests.
+func newFailoverTestServer(t *testing.T, status string, requests *atomic.Int32) *url.URL {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		body, _ := io.ReadAll(r.Body)
+
+		var code int
+
+		switch status {
+		case "ok":
+			code = http.StatusOK
+		case "unavailable":
+			code = http.StatusServiceUnavailable
+		default:
+			code = http.StatusInternalServerError
+		}
+
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(r.URL.EscapedPath() + " " + string(body)))
+	}))
+	t.Cleanup(server.Close)
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	return serverURL.JoinPath("/api2/json")
+}
+
+func Test_failoverTransport(t *testing.T) {
+	var (
+		unavailableRequests atomic.Int32
+		healthyRequests     atomic.Int32
+		switchovers         atomic.Int32
+	)
+
+	// Nothing listens on the first endpoint
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL, err := url.Parse(closed.URL)
+	require.NoError(t, err)
+	closed.Close()
+
+	urls := []*url.URL{
+		closedURL.JoinPath("/api2/json"),
+		newFailoverTestServer(t, "unavailable", &unavailableRequests),
+		newFailoverTestServer(t, "ok", &healthyRequests),
+	}
+
+	transport := newFailoverTransport(http.DefaultTransport, hclog.NewNullLogger(), urls, func(endpoint *url.URL) {
+		require.Equal(t, urls[2].Host, endpoint.Host)
+		switchovers.Add(1)
+	})
+	client := http.Client{Transport: transport}
+
+	// Request is made to the first endpoint and ends up on the healthy one with body and path preserved
+	request, err := http.NewRequest(http.MethodPost, urls[0].JoinPath("/nodes").String(), bytes.NewBufferString("data"))
+	require.NoError(t, err)
+
+	response, err := client.Do(request)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	response.Body.Close()
+
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "/api2/json/nodes data", string(body))
+	require.Equal(t, int32(1), unavailableRequests.Load())
+	require.Equal(t, int32(1), healthyRequests.Load())
+	require.Equal(t, int32(1), switchovers.Load())
+
+	// Following requests go straight to the active endpoint
+	response, err = client.Get(urls[0].JoinPath("/version").String())
+	require.NoError(t, err)
+	response.Body.Close()
+
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, int32(1), unavailableRequests.Load())
+	require.Equal(t, int32(2), healthyRequests.Load())
+	require.Equal(t, int32(1), switchovers.Load())
+}
+
+func Test_failoverTransport_escapedPath(t *testing.T) {
+	var requests atomic.Int32
+
+	// Nothing listens on the first endpoint
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL, err := url.Parse(closed.URL)
+	require.NoError(t, err)
+	closed.Close()
+
+	healthyURL := newFailoverTestServer(t, "ok", &requests)
+
+	tests := map[string][]*url.URL{
+		"Single endpoint": {healthyURL},
+		"Switchover":      {closedURL.JoinPath("/api2/json"), healthyURL},
+	}
+
+	for name, urls := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := http.Client{Transport: newFailoverTransport(http.DefaultTransport, hclog.NewNullLogger(), urls, nil)}
+
+			request, err := http.NewRequest(http.MethodDelete, urls[0].String()+"/nodes/pve1/qemu/100/firewall/ipset/fleeting-allowed/10.0.0.0%2F8", nil)
+			require.NoError(t, err)
+
+			response, err := client.Do(request)
+			require.NoError(t, err)
+
+			body, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+			response.Body.Close()
+
+			require.Equal(t, "/api2/json/nodes/pve1/qemu/100/firewall/ipset/fleeting-allowed/10.0.0.0%2F8 ", string(body))
+		})
+	}
+}
+
+func Test_failoverTransport_allEndpointsFail(t *testing.T) {
+	var requests atomic.Int32
+
+	urls := []*url.URL{
+		newFailoverTestServer(t, "unavailable", &requests),
+		newFailoverTestServer(t, "unavailable", &requests),
+	}
+
+	client := http.Client{Transport: newFailoverTransport(http.DefaultTransport, hclog.NewNullLogger(), urls, nil)}
+
+	// Last endpoint's response is returned
+	response, err := client.Get(urls[0].String())
+	require.NoError(t, err)
+	response.Body.Close()
+
+	require.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	require.Equal(t, int32(2), requests.Load())
+}
+
+func Test_failoverTransport_requestError(t *testing.T) {
+	var failingRequests, healthyRequests atomic.Int32
+
+	urls := []*url.URL{
+		newFailoverTestServer(t, "error", &failingRequests),
+		newFailoverTestServer(t, "ok", &healthyRequests),
+	}
+
+	client := http.Client{Transport: newFailoverTransport(http.DefaultTransport, hclog.NewNullLogger(), urls, nil)}
+
+	// Errors of the request itself are not failed over
+	response, err := client.Get(urls[0].String())
+	require.NoError(t, err)
+	response.Body.Close()
+
+	require.Equal(t, http.StatusInternalServerError, response.StatusCode)
+	require.Equal(t, int32(1), failingRequests.Load())
+	require.Equal(t, int32(0), healthyRequests.Load())
+}
+
+func Test_isEndpointFailure(t *testing.T) {
+	require.True(t, isEndpointFailure(&http.Response{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"}))
+	require.True(t, isEndpointFailure(&http.Response{StatusCode: http.StatusInternalServerError, Status: "500 cluster not ready - no quorum?"}))
+	require.False(t, isEndpointFailure(&http.Response{StatusCode: http.StatusInternalServerError, Status: "500 unable to create VM 100"}))
+	require.False(t, isEndpointFailure(&http.Response{StatusCode: http.StatusOK, Status: "200 OK"}))
+}
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// Request received by fake Proxmox VE API.
+type recordedRequest struct {
+	Method string
+	Path   string
+	Body   map[string]any
+}
+
+// Starts fake Proxmox VE API responding to GET requests with given data and recording all other requests.
+func newRecordingServer(t *testing.T, responses map[string]string) (*proxmox.Client, func() []recordedRequest) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []recordedRequest
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			response, ok := responses[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"data":` + response + `}`))
+
+			return
+		}
+
+		body := map[string]any{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		// Escaped path is recorded, as Proxmox VE routes requests by it
+		mu.Lock()
+		requests = append(requests, recordedRequest{Method: r.Method, Path: r.URL.EscapedPath(), Body: body})
+		mu.Unlock()
+
+		_, _ = w.Write([]byte(`{"data":null}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return proxmox.NewClient(server.URL), func() []recordedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return requests
+	}
+}
@@ -0,0 +1,28 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeFileAtomically(t *testing.T) {
+	directory := t.TempDir()
+	path := filepath.Join(directory, "state")
+
+	require.NoError(t, writeFileAtomically(path, []byte("first")))
+	require.NoError(t, writeFileAtomically(path, []byte("second")))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "second", string(data))
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(directory)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	require.Error(t, writeFileAtomically(filepath.Join(directory, "missing", "state"), []byte("data")))
+}
@@ -0,0 +1,231 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+const (
+	// Comment of firewall rules added by the plugin, used to replace them when deployment is resumed.
+	firewallRuleComment = "fleeting-plugin-proxmox"
+
+	// Name of IP set with allowed destinations.
+	firewallAllowedDestinationsIPSet = "fleeting-allowed"
+)
+
+var ErrFirewallUnavailable = errors.New("firewall can't be applied")
+
+// Firewall rule as listed by Proxmox VE, only fields used by the plugin are decoded.
+type firewallRuleStatus struct {
+	Pos     int    `json:"pos"`
+	Comment string `json:"comment"`
+}
+
+// Firewall IP set as listed by Proxmox VE.
+type firewallIPSet struct {
+	Name string `json:"name"`
+}
+
+// Entry of firewall IP set as listed by Proxmox VE.
+type firewallIPSetEntry struct {
+	CIDR string `json:"cidr"`
+}
+
+// Checks that configured firewall can be applied to instances.
+func (ig *InstanceGroup) verifyFirewall(ctx context.Context) error {
+	if !ig.Settings.Firewall.Enabled {
+		return nil
+	}
+
+	options := struct {
+		Enable int `json:"enable"`
+	}{}
+
+	if err := ig.proxmox.Get(ctx, "/cluster/firewall/options", &options); err != nil {
+		return fmt.Errorf("failed to get cluster firewall options: %w", err)
+	}
+
+	// Rules of VMs have no effect unless firewall is enabled for the whole cluster
+	if options.Enable != 1 {
+		return fmt.Errorf("%w: firewall is disabled for the cluster", ErrFirewallUnavailable)
+	}
+
+	if ig.Settings.Firewall.SecurityGroup == "" {
+		return nil
+	}
+
+	groups := []struct {
+		Group string `json:"group"`
+	}{}
+
+	if err := ig.proxmox.Get(ctx, "/cluster/firewall/groups", &groups); err != nil {
+		return fmt.Errorf("failed to list firewall security groups: %w", err)
+	}
+
+	for _, group := range groups {
+		if group.Group == ig.Settings.Firewall.SecurityGroup {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: security group='%s' does not exist", ErrFirewallUnavailable, ig.Settings.Firewall.SecurityGroup)
+}
+
+// Applies configured firewall to the instance, replacing rules added previously by the plugin.
+func (ig *InstanceGroup) configureInstanceFirewall(ctx context.Context, vm *proxmox.VirtualMachine) error {
+	settings := &ig.Settings.Firewall
+
+	if !settings.Enabled {
+		return nil
+	}
+
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/firewall", vm.Node, vm.VMID)
+
+	if err := ig.removeInstanceFirewallRules(ctx, path); err != nil {
+		return err
+	}
+
+	rules := []map[string]any{}
+
+	if settings.SecurityGroup != "" {
+		rules = append(rules, map[string]any{"type": "group", "action": settings.SecurityGroup})
+	}
+
+	for _, rule := range settings.Rules {
+		rules = append(rules, map[string]any{
+			"type":   rule.Type,
+			"action": rule.Action,
+			"macro":  rule.Macro,
+			"source": rule.Source,
+			"dest":   rule.Dest,
+			"proto":  rule.Proto,
+			"dport":  rule.Dport,
+			"sport":  rule.Sport,
+		})
+	}
+
+	if len(settings.AllowedDestinations) > 0 {
+		if err := ig.configureAllowedDestinations(ctx, path); err != nil {
+			return err
+		}
+
+		rules = append(rules, map[string]any{"type": "out", "action": "ACCEPT", "dest": "+" + firewallAllowedDestinationsIPSet})
+	}
+
+	// New rules are inserted at the top, so they are added in reverse to keep configured order
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		rule["enable"] = 1
+		rule["comment"] = firewallRuleComment
+
+		for key, value := range rule {
+			if value == "" {
+				delete(rule, key)
+			}
+		}
+
+		if err := ig.proxmox.Post(ctx, path+"/rules", rule, nil); err != nil {
+			return fmt.Errorf("failed to add firewall rule to vm='%d': %w", vm.VMID, err)
+		}
+	}
+
+	// Instances get their addresses with DHCP, so it must not be blocked by the policies
+	options := map[string]any{"enable": 1, "dhcp": 1}
+
+	if settings.PolicyIn != "" {
+		options["policy_in"] = settings.PolicyIn
+	}
+
+	if settings.PolicyOut != "" {
+		options["policy_out"] = settings.PolicyOut
+	}
+
+	if err := ig.proxmox.Put(ctx, path+"/options", options, nil); err != nil {
+		return fmt.Errorf("failed to enable firewall on vm='%d': %w", vm.VMID, err)
+	}
+
+	// Firewall applies only to network devices with firewall flag
+	return ig.updateInstanceNetworkDevice(ctx, vm, func(device *networkDevice) error {
+		device.set("firewall", "1")
+		return nil
+	})
+}
+
+// Removes firewall rules previously added by the plugin, e.g. before deployment was interrupted.
+func (ig *InstanceGroup) removeInstanceFirewallRules(ctx context.Context, path string) error {
+	rules := []firewallRuleStatus{}
+	if err := ig.proxmox.Get(ctx, path+"/rules", &rules); err != nil {
+		return fmt.Errorf("failed to list firewall rules: %w", err)
+	}
+
+	// Positions of the following rules change after removal, so rules are removed from the bottom
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Pos > rules[j].Pos })
+
+	for _, rule := range rules {
+		if rule.Comment != firewallRuleComment {
+			continue
+		}
+
+		if err := ig.proxmox.Delete(ctx, fmt.Sprintf("%s/rules/%d", path, rule.Pos), nil); err != nil {
+			return fmt.Errorf("failed to remove firewall rule at pos='%d': %w", rule.Pos, err)
+		}
+	}
+
+	return nil
+}
+
+// Creates IP set with allowed destinations, adding only entries which are missing and removing entries which are not
+// allowed anymore, e.g. cloned from template configured with different allowed destinations.
+func (ig *InstanceGroup) configureAllowedDestinations(ctx context.Context, path string) error {
+	ipSets := []firewallIPSet{}
+
+	if err := ig.proxmox.Get(ctx, path+"/ipset", &ipSets); err != nil {
+		return fmt.Errorf("failed to list firewall IP sets: %w", err)
+	}
+
+	exists := slices.ContainsFunc(ipSets, func(ipSet firewallIPSet) bool {
+		return ipSet.Name == firewallAllowedDestinationsIPSet
+	})
+
+	entries := []firewallIPSetEntry{}
+
+	if exists {
+		if err := ig.proxmox.Get(ctx, path+"/ipset/"+firewallAllowedDestinationsIPSet, &entries); err != nil {
+			return fmt.Errorf("failed to list entries of firewall IP set='%s': %w", firewallAllowedDestinationsIPSet, err)
+		}
+	} else {
+		ipSet := map[string]any{"name": firewallAllowedDestinationsIPSet, "comment": firewallRuleComment}
+		if err := ig.proxmox.Post(ctx, path+"/ipset", ipSet, nil); err != nil {
+			return fmt.Errorf("failed to create firewall IP set='%s': %w", firewallAllowedDestinationsIPSet, err)
+		}
+	}
+
+	for _, entry := range entries {
+		if slices.Contains(ig.Settings.Firewall.AllowedDestinations, entry.CIDR) {
+			continue
+		}
+
+		if err := ig.proxmox.Delete(ctx, path+"/ipset/"+firewallAllowedDestinationsIPSet+"/"+url.PathEscape(entry.CIDR), nil); err != nil {
+			return fmt.Errorf("failed to remove destination='%s' from firewall IP set: %w", entry.CIDR, err)
+		}
+	}
+
+	for _, destination := range ig.Settings.Firewall.AllowedDestinations {
+		if slices.ContainsFunc(entries, func(entry firewallIPSetEntry) bool { return entry.CIDR == destination }) {
+			continue
+		}
+
+		entry := map[string]any{"cidr": destination}
+		if err := ig.proxmox.Post(ctx, path+"/ipset/"+firewallAllowedDestinationsIPSet, entry, nil); err != nil {
+			return fmt.Errorf("failed to add destination='%s' to firewall IP set: %w", destination, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceGroup_configureInstanceFirewall(t *testing.T) {
+	client, requests := newRecordingServer(t, map[string]string{
+		"/nodes/pve1/qemu/100/firewall/rules": `[
+			{"pos":0,"comment":"fleeting-plugin-proxmox"},
+			{"pos":1,"comment":"from template"},
+			{"pos":2,"comment":"fleeting-plugin-proxmox"}
+		]`,
+		"/nodes/pve1/qemu/100/firewall/ipset": `[]`,
+	})
+
+	ig := InstanceGroup{
+		Settings: Settings{
+			Firewall: FirewallSettings{
+				Enabled:       true,
+				PolicyIn:      "DROP",
+				PolicyOut:     "DROP",
+				SecurityGroup: "runners",
+				Rules: []FirewallRule{
+					{Type: "in", Action: "ACCEPT", Proto: "tcp", Dport: "22"},
+				},
+				AllowedDestinations: []string{"10.0.0.0/8", "192.0.2.1"},
+			},
+		},
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+	}
+
+	vm := &proxmox.VirtualMachine{
+		Node:                 "pve1",
+		VMID:                 100,
+		VirtualMachineConfig: &proxmox.VirtualMachineConfig{Net0: "virtio=BC:24:11:00:00:01,bridge=vmbr0"},
+	}
+
+	require.NoError(t, ig.configureInstanceFirewall(context.Background(), vm))
+
+	comment := firewallRuleComment
+	expected := []recordedRequest{
+		// Rules from previous attempt, from the bottom
+		{Method: http.MethodDelete, Path: "/nodes/pve1/qemu/100/firewall/rules/2", Body: map[string]any{}},
+		{Method: http.MethodDelete, Path: "/nodes/pve1/qemu/100/firewall/rules/0", Body: map[string]any{}},
+		// IP set
+		{Method: http.MethodPost, Path: "/nodes/pve1/qemu/100/firewall/ipset", Body: map[string]any{"name": firewallAllowedDestinationsIPSet, "comment": comment}},
+		{Method: http.MethodPost, Path: "/nodes/pve1/qemu/100/firewall/ipset/fleeting-allowed", Body: map[string]any{"cidr": "10.0.0.0/8"}},
+		{Method: http.MethodPost, Path: "/nodes/pve1/qemu/100/firewall/ipset/fleeting-allowed", Body: map[string]any{"cidr": "192.0.2.1"}},
+		// Rules in reverse
+		{Method: http.MethodPost, Path: "/nodes/pve1/qemu/100/firewall/rules", Body: map[string]any{"type": "out", "action": "ACCEPT", "dest": "+fleeting-allowed", "enable": float64(1), "comment": comment}},
+		{Method: http.MethodPost, Path: "/nodes/pve1/qemu/100/firewall/rules", Body: map[string]any{"type": "in", "action": "ACCEPT", "proto": "tcp", "dport": "22", "enable": float64(1), "comment": comment}},
+		{Method: http.MethodPost, Path: "/nodes/pve1/qemu/100/firewall/rules", Body: map[string]any{"type": "group", "action": "runners", "enable": float64(1), "comment": comment}},
+		// Options and network device
+		{Method: http.MethodPut, Path: "/nodes/pve1/qemu/100/firewall/options", Body: map[string]any{"enable": float64(1), "dhcp": float64(1), "policy_in": "DROP", "policy_out": "DROP"}},
+		{Method: http.MethodPut, Path: "/nodes/pve1/qemu/100/config", Body: map[string]any{"net0": "virtio=BC:24:11:00:00:01,bridge=vmbr0,firewall=1"}},
+	}
+
+	require.Equal(t, expected, requests())
+}
+
+func TestInstanceGroup_configureAllowedDestinations(t *testing.T) {
+	path := "/nodes/pve1/qemu/100/firewall"
+
+	client, requests := newRecordingServer(t, map[string]string{
+		path + "/ipset":                  `[{"name":"fleeting-allowed"}]`,
+		path + "/ipset/fleeting-allowed": `[{"cidr":"10.0.0.0/8"},{"cidr":"172.16.0.0/12"}]`,
+	})
+
+	ig := InstanceGroup{
+		Settings: Settings{
+			Firewall: FirewallSettings{AllowedDestinations: []string{"10.0.0.0/8", "192.0.2.1"}},
+		},
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+	}
+
+	require.NoError(t, ig.configureAllowedDestinations(context.Background(), path))
+
+	// Entries not allowed anymore are removed, missing ones are added and existing ones are kept
+	require.Equal(t, []recordedRequest{
+		{Method: http.MethodDelete, Path: path + "/ipset/fleeting-allowed/172.16.0.0%2F12", Body: map[string]any{}},
+		{Method: http.MethodPost, Path: path + "/ipset/fleeting-allowed", Body: map[string]any{"cidr": "192.0.2.1"}},
+	}, requests())
+}
+
+func TestInstanceGroup_verifyFirewall(t *testing.T) {
+	tests := []struct {
+		name          string
+		options       string
+		securityGroup string
+		expectedError error
+	}{
+		{
+			name:          "Enabled",
+			options:       `{"enable":1}`,
+			securityGroup: "runners",
+			expectedError: nil,
+		},
+		{
+			name:          "Disabled for cluster",
+			options:       `{"enable":0}`,
+			expectedError: ErrFirewallUnavailable,
+		},
+		{
+			name:          "Missing security group",
+			options:       `{"enable":1}`,
+			securityGroup: "missing",
+			expectedError: ErrFirewallUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newRecordingServer(t, map[string]string{
+				"/cluster/firewall/options": tt.options,
+				"/cluster/firewall/groups":  `[{"group":"runners"}]`,
+			})
+
+			ig := InstanceGroup{
+				Settings: Settings{Firewall: FirewallSettings{Enabled: true, SecurityGroup: tt.securityGroup}},
+				proxmox:  client,
+			}
+
+			require.ErrorIs(t, ig.verifyFirewall(context.Background()), tt.expectedError)
+		})
+	}
+}
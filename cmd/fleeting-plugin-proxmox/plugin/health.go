@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// Number of consecutive failed QEMU guest agent pings after which the instance is considered broken.
+const agentPingFailureThreshold = 3
+
+// Marks unhealthy instances for removal, so they are replaced. Runs in the collector as QEMU guest agent pings might
+// take long and must not block updates.
+func (ig *InstanceGroup) replaceUnhealthyInstances(ctx context.Context, members []proxmox.ClusterResource) {
+	unhealthy := ig.findUnhealthyInstances(ctx, members)
+
+	if len(unhealthy) < 1 {
+		return
+	}
+
+	ig.log.Info("marking unhealthy instances for removal", "count", len(unhealthy))
+
+	if err := ig.markInstancesForRemoval(ctx, unhealthy...); err != nil {
+		ig.log.Error("failed to mark unhealthy instances for removal", "err", err)
+	}
+}
+
+// Returns running instances which are stopped or, if enabled, which QEMU guest agent stopped responding.
+func (ig *InstanceGroup) findUnhealthyInstances(ctx context.Context, members []proxmox.ClusterResource) []*proxmox.ClusterResource {
+	var (
+		unhealthy   = []*proxmox.ClusterResource{}
+		unhealthyMu sync.Mutex
+		wg          sync.WaitGroup
+	)
+
+	ig.agentPingFailuresMu.Lock()
+	defer ig.agentPingFailuresMu.Unlock()
+
+	failures := make(map[uint64]int, len(members))
+
+	for _, member := range members {
+		member := member
+
+		if !ig.isProxmoxResourceAnInstance(member) || member.Name != ig.Settings.InstanceNameRunning {
+			continue
+		}
+
+		if member.Status != "running" {
+			ig.log.Warn("found running instance which is not running in proxmox", "vmid", member.VMID, "node", member.Node, "status", member.Status)
+
+			// Agent pings of previous members append concurrently
+			unhealthyMu.Lock()
+			unhealthy = append(unhealthy, &member)
+			unhealthyMu.Unlock()
+
+			continue
+		}
+
+		if !ig.Settings.HealthCheckAgent {
+			continue
+		}
+
+		previousFailures := ig.agentPingFailures[member.VMID]
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := ig.agentPing(ctx, &member)
+
+			unhealthyMu.Lock()
+			defer unhealthyMu.Unlock()
+
+			if err == nil {
+				failures[member.VMID] = 0
+				return
+			}
+
+			failures[member.VMID] = previousFailures + 1
+			ig.log.Warn("qemu agent of running instance did not respond", "vmid", member.VMID, "node", member.Node, "failures", failures[member.VMID], "err", err)
+
+			if failures[member.VMID] >= agentPingFailureThreshold {
+				unhealthy = append(unhealthy, &member)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	ig.agentPingFailures = failures
+
+	return unhealthy
+}
+
+// Checks if QEMU guest agent of the VM responds.
+func (ig *InstanceGroup) agentPing(ctx context.Context, member *proxmox.ClusterResource) error {
+	if err := ig.proxmox.Post(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/ping", member.Node, member.VMID), nil, nil); err != nil {
+		return fmt.Errorf("failed to ping qemu agent on vm='%d': %w", member.VMID, err)
+	}
+
+	return nil
+}
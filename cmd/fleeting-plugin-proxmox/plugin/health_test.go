@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceGroup_findUnhealthyInstances(t *testing.T) {
+	ig := InstanceGroup{
+		Settings: Settings{
+			TemplateID: &sampleTemplateID,
+		},
+		log: hclog.NewNullLogger(),
+	}
+	ig.Settings.FillWithDefaults()
+
+	unhealthy := ig.findUnhealthyInstances(context.Background(), []proxmox.ClusterResource{
+		{Type: "qemu", VMID: 100, Name: "fleeting-running", Status: "running"},
+		{Type: "qemu", VMID: 101, Name: "fleeting-running", Status: "stopped"},
+		{Type: "qemu", VMID: 102, Name: "fleeting-creating", Status: "stopped"},
+		{Type: "qemu", VMID: 103, Name: "fleeting-removing", Status: "stopped"},
+		{Type: "qemu", VMID: uint64(sampleTemplateID), Name: "fleeting-running", Status: "stopped"},
+	})
+
+	require.Len(t, unhealthy, 1)
+	require.Equal(t, uint64(101), unhealthy[0].VMID)
+}
+
+func TestInstanceGroup_findUnhealthyInstances_agentPingFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/nodes/pve1/qemu/101/agent/ping" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"data":null}`))
+	}))
+	t.Cleanup(server.Close)
+
+	ig := InstanceGroup{
+		Settings: Settings{
+			TemplateID:       &sampleTemplateID,
+			HealthCheckAgent: true,
+		},
+		log:     hclog.NewNullLogger(),
+		proxmox: proxmox.NewClient(server.URL),
+	}
+	ig.Settings.FillWithDefaults()
+
+	members := []proxmox.ClusterResource{
+		{Type: "qemu", Node: "pve1", VMID: 100, Name: "fleeting-running", Status: "running"},
+		{Type: "qemu", Node: "pve1", VMID: 101, Name: "fleeting-running", Status: "running"},
+	}
+
+	// Instance is replaced only after agent fails to respond the threshold number of times in a row
+	for attempt := 1; attempt < agentPingFailureThreshold; attempt++ {
+		require.Empty(t, ig.findUnhealthyInstances(context.Background(), members))
+		require.Equal(t, map[uint64]int{100: 0, 101: attempt}, ig.agentPingFailures)
+	}
+
+	unhealthy := ig.findUnhealthyInstances(context.Background(), members)
+	require.Len(t, unhealthy, 1)
+	require.Equal(t, uint64(101), unhealthy[0].VMID)
+
+	// Failures of instances which are not running instances anymore are forgotten
+	members[1].Name = "fleeting-removing"
+	require.Empty(t, ig.findUnhealthyInstances(context.Background(), members))
+	require.Equal(t, map[uint64]int{100: 0}, ig.agentPingFailures)
+}
+
+// Log output which slows down finding stopped instances, so agent pings finish meanwhile.
+type slowStoppedInstanceWriter struct{}
+
+func (slowStoppedInstanceWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("not running in proxmox")) {
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	return len(p), nil
+}
+
+func TestInstanceGroup_findUnhealthyInstances_mixed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/nodes/pve1/qemu/100/agent/ping" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"data":null}`))
+	}))
+	t.Cleanup(server.Close)
+
+	ig := InstanceGroup{
+		Settings: Settings{
+			TemplateID:       &sampleTemplateID,
+			HealthCheckAgent: true,
+		},
+		log:               hclog.New(&hclog.LoggerOptions{Output: slowStoppedInstanceWriter{}, Level: hclog.Warn}),
+		proxmox:           proxmox.NewClient(server.URL),
+		agentPingFailures: map[uint64]int{},
+	}
+	ig.Settings.FillWithDefaults()
+
+	// Agents of running instances are pinged while many stopped instances are still being found
+	members := []proxmox.ClusterResource{}
+	expected := []uint64{}
+
+	for vmid := uint64(100); vmid < 110; vmid++ {
+		members = append(members, proxmox.ClusterResource{Type: "qemu", Node: "pve1", VMID: vmid, Name: "fleeting-running", Status: "running"})
+		ig.agentPingFailures[vmid] = agentPingFailureThreshold - 1
+
+		if vmid != 100 {
+			expected = append(expected, vmid)
+		}
+	}
+
+	for vmid := uint64(1000); vmid < 1100; vmid++ {
+		members = append(members, proxmox.ClusterResource{Type: "qemu", Node: "pve1", VMID: vmid, Name: "fleeting-running", Status: "stopped"})
+		expected = append(expected, vmid)
+	}
+
+	unhealthy := ig.findUnhealthyInstances(context.Background(), members)
+
+	vmids := []uint64{}
+	for _, instance := range unhealthy {
+		vmids = append(vmids, instance.VMID)
+	}
+
+	require.ElementsMatch(t, expected, vmids)
+}
@@ -0,0 +1,241 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/luthermonson/go-proxmox"
+	"gitlab.com/gitlab-org/fleeting/fleeting/provider"
+)
+
+// Prefix of comment marking known hosts entries added by the plugin, followed by VMID.
+const knownHostsCommentPrefix = "fleeting-plugin-proxmox:vmid="
+
+var (
+	ErrNoHostKey      = errors.New("failed to read any ssh host key of instance")
+	ErrInvalidHostKey = errors.New("invalid ssh host key")
+)
+
+// Types of SSH host keys read from instances, in order of preference.
+var hostKeyTypes = []string{"ed25519", "ecdsa", "rsa"}
+
+// Known hosts file with SSH host keys of instances, entries not added by the plugin are kept.
+// A nil value stands for a disabled file, all of its methods then do nothing.
+type knownHosts struct {
+	path string
+
+	mu sync.Mutex
+}
+
+// Returns known hosts file at given path, an empty path disables it.
+func openKnownHosts(path string) *knownHosts {
+	if path == "" {
+		return nil
+	}
+
+	return &knownHosts{path: path}
+}
+
+// Replaces entries of given instance and entries of other instances with the same hosts.
+func (k *knownHosts) set(vmid int, hosts []string, keys []string) error {
+	if k == nil {
+		return nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	lines, err := k.read()
+	if err != nil {
+		return err
+	}
+
+	// Addresses are reused by new instances, so keys of removed ones must not be trusted anymore
+	lines = slices.DeleteFunc(lines, func(line string) bool {
+		lineVMID, lineHosts, ok := parseKnownHostsLine(line)
+		return ok && (lineVMID == vmid || slices.ContainsFunc(lineHosts, func(host string) bool { return slices.Contains(hosts, host) }))
+	})
+
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s %s %s%d", strings.Join(hosts, ","), key, knownHostsCommentPrefix, vmid))
+	}
+
+	return k.write(lines)
+}
+
+// Removes entries of given instance.
+func (k *knownHosts) remove(vmid int) error {
+	if k == nil {
+		return nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	lines, err := k.read()
+	if err != nil {
+		return err
+	}
+
+	remaining := slices.DeleteFunc(slices.Clone(lines), func(line string) bool {
+		lineVMID, _, ok := parseKnownHostsLine(line)
+		return ok && lineVMID == vmid
+	})
+
+	if len(remaining) == len(lines) {
+		return nil
+	}
+
+	return k.write(remaining)
+}
+
+// Reads lines of known hosts file, must be called with the mutex held.
+func (k *knownHosts) read() ([]string, error) {
+	data, err := os.ReadFile(k.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts from path='%s': %w", k.path, err)
+	}
+
+	lines := []string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines, nil
+}
+
+// Atomically writes known hosts file, must be called with the mutex held.
+func (k *knownHosts) write(lines []string) error {
+	data := []byte{}
+	if len(lines) > 0 {
+		data = []byte(strings.Join(lines, "\n") + "\n")
+	}
+
+	if err := writeFileAtomically(k.path, data); err != nil {
+		return fmt.Errorf("failed to save known hosts to path='%s': %w", k.path, err)
+	}
+
+	return nil
+}
+
+// Parses VMID and hosts of entry added by the plugin, reports false for other lines.
+func parseKnownHostsLine(line string) (int, []string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || !strings.HasPrefix(fields[len(fields)-1], knownHostsCommentPrefix) {
+		return 0, nil, false
+	}
+
+	vmid, err := strconv.Atoi(strings.TrimPrefix(fields[len(fields)-1], knownHostsCommentPrefix))
+	if err != nil {
+		return 0, nil, false
+	}
+
+	return vmid, strings.Split(fields[0], ","), true
+}
+
+// Parses public key file like "ssh-ed25519 AAAA... root@host", returning key type and key without the comment.
+func parseHostKey(content string) (string, error) {
+	fields := strings.Fields(content)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("%w: expected key type and key", ErrInvalidHostKey)
+	}
+
+	if !strings.HasPrefix(fields[0], "ssh-") && !strings.HasPrefix(fields[0], "ecdsa-") {
+		return "", fmt.Errorf("%w: unsupported key type='%s'", ErrInvalidHostKey, fields[0])
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(fields[1]); err != nil {
+		return "", fmt.Errorf("%w: key is not base64 encoded: %w", ErrInvalidHostKey, err)
+	}
+
+	return fields[0] + " " + fields[1], nil
+}
+
+// Returns directory with SSH host keys on given OS.
+func hostKeysDirectory(instanceOS string) string {
+	if instanceOS == "windows" {
+		return `C:\ProgramData\ssh\`
+	}
+
+	return "/etc/ssh/"
+}
+
+// Reads SSH host keys of newly deployed instance with QEMU guest agent and records them in known hosts file.
+func (ig *InstanceGroup) recordInstanceHostKeys(ctx context.Context, vm *proxmox.VirtualMachine) error {
+	if ig.knownHosts == nil {
+		return nil
+	}
+
+	config, err := ig.getInstanceConnectorConfig(ctx, vm.Node, uint64(vm.VMID))
+	if err != nil {
+		return err
+	}
+
+	if config.Protocol == provider.ProtocolWinRM {
+		return nil
+	}
+
+	keys := []string{}
+
+	for _, keyType := range hostKeyTypes {
+		path := hostKeysDirectory(config.OS) + "ssh_host_" + keyType + "_key.pub"
+
+		// Not every key type is generated by every distribution
+		result, err := ig.agentFileRead(ctx, vm, path)
+		if err != nil {
+			ig.log.Debug("failed to read ssh host key", "vmid", vm.VMID, "path", path, "err", err)
+			continue
+		}
+
+		key, err := parseHostKey(result.Content)
+		if err != nil {
+			return fmt.Errorf("failed to parse ssh host key from path='%s': %w", path, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("%w: vmid='%d'", ErrNoHostKey, vm.VMID)
+	}
+
+	addresses, err := ig.getInstanceAddresses(ctx, vm.Node, uint64(vm.VMID))
+	if err != nil {
+		return err
+	}
+
+	hosts := []string{addresses.internal}
+	if addresses.external != "" && addresses.external != addresses.internal {
+		hosts = append(hosts, addresses.external)
+	}
+
+	hosts = slices.DeleteFunc(hosts, func(host string) bool { return host == "" })
+
+	if err := ig.knownHosts.set(int(vm.VMID), hosts, keys); err != nil {
+		return fmt.Errorf("failed to record ssh host keys: %w", err)
+	}
+
+	return nil
+}
+
+// Removes SSH host keys of the instance from known hosts file, failures are only logged.
+func (ig *InstanceGroup) knownHostsRemove(vmid int) {
+	if err := ig.knownHosts.remove(vmid); err != nil {
+		ig.log.Error("failed to remove ssh host keys from known hosts", "vmid", vmid, "err", err)
+	}
+}
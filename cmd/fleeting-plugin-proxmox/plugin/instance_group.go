@@ -25,6 +25,9 @@ type InstanceGroup struct {
 	log     hclog.Logger    `json:"-"`
 	proxmox *proxmox.Client `json:"-"`
 
+	// Authenticates requests made by Proxmox VE client.
+	auth *authTransport `json:"-"`
+
 	// This mutex is used when cloning template for new instances. It is required for blocking other
 	// operations like collection or update, because when new instance is created with recycled ID then for
 	// a brief period it will be reported from Proxmox with old name (e.g. InstanceNameRemoving).
@@ -42,8 +45,50 @@ type InstanceGroup struct {
 	// Trigger to shutdown session ticket refresher.
 	sessionTicketRefresherShutdownTrigger chan struct{} `json:"-"`
 
+	// Trigger for session ticket refresher to refresh the ticket immediately.
+	sessionTicketRefreshTrigger chan struct{} `json:"-"`
+
 	// Wait group for session ticket refresher.
 	sessionTicketRefresherWaitGroup sync.WaitGroup `json:"-"`
+
+	// Cache of cluster resources.
+	inventory inventory `json:"-"`
+
+	// Consecutive failed QEMU guest agent pings per instance.
+	agentPingFailures map[uint64]int `json:"-"`
+
+	// Mutex for agent ping failures.
+	agentPingFailuresMu sync.Mutex `json:"-"`
+
+	// Running instances on nodes in maintenance mode by time draining started, reported as deleting.
+	drainingInstances map[uint64]time.Time `json:"-"`
+
+	// Mutex for draining instances.
+	drainingInstancesMu sync.Mutex `json:"-"`
+
+	// Addresses of instances discovered after deployment, so they don't have to be read with QEMU guest agent again.
+	instanceAddresses map[uint64]instanceAddresses `json:"-"`
+
+	// Mutex for instance addresses.
+	instanceAddressesMu sync.Mutex `json:"-"`
+
+	// Connector configs of instances with detected OS and generated password.
+	instanceConnectorConfigs map[uint64]provider.ConnectorConfig `json:"-"`
+
+	// Mutex for instance connector configs.
+	instanceConnectorConfigsMu sync.Mutex `json:"-"`
+
+	// Known hosts file with SSH host keys of instances, nil if disabled.
+	knownHosts *knownHosts `json:"-"`
+
+	// Journal of in-flight operations, nil if disabled.
+	journal *journal `json:"-"`
+
+	// Cancels deployments resumed from the journal.
+	resumedDeploymentsCancel context.CancelFunc `json:"-"`
+
+	// Wait group for deployments resumed from the journal.
+	resumedDeploymentsWaitGroup sync.WaitGroup `json:"-"`
 }
 
 // Init implements provider.InstanceGroup.
@@ -55,6 +100,7 @@ func (ig *InstanceGroup) Init(ctx context.Context, logger hclog.Logger, settings
 	ig.instanceCollectionTrigger = make(chan struct{}, triggerChannelCapacity)
 	ig.collectorShutdownTrigger = make(chan struct{}, 1)
 	ig.sessionTicketRefresherShutdownTrigger = make(chan struct{}, 1)
+	ig.sessionTicketRefreshTrigger = make(chan struct{}, 1)
 
 	if err := ig.Settings.CheckRequiredFields(); err != nil {
 		return provider.ProviderInfo{}, err
@@ -62,11 +108,36 @@ func (ig *InstanceGroup) Init(ctx context.Context, logger hclog.Logger, settings
 
 	ig.Settings.FillWithDefaults()
 
+	if ig.Settings.InstancePassword && settings.ConnectorConfig.Username == "" {
+		return provider.ProviderInfo{}, fmt.Errorf("%w: instance_password: connector_config username is required", ErrSettingInvalidParameter)
+	}
+
 	if ig.Settings.InsecureSkipTLSVerify {
 		ig.log.Warn("TLS verification for Proxmox client is disabled, connections will be insecure")
 	}
 
-	ig.proxmox, err = ig.getProxmoxClient()
+	ig.proxmox, ig.auth, err = ig.getProxmoxClient()
+	if err != nil {
+		return provider.ProviderInfo{}, err
+	}
+
+	// Make sure the pool exists as its members are later read from cluster resources
+	if _, err := ig.getProxmoxPool(ctx); err != nil {
+		return provider.ProviderInfo{}, err
+	}
+
+	if err := ig.verifyFirewall(ctx); err != nil {
+		return provider.ProviderInfo{}, err
+	}
+
+	ig.knownHosts = openKnownHosts(ig.Settings.KnownHostsPath)
+
+	ig.journal, err = openJournal(ig.Settings.StateJournalPath)
+	if err != nil {
+		return provider.ProviderInfo{}, err
+	}
+
+	deploymentsToResume, err := ig.recoverFromJournal(ctx)
 	if err != nil {
 		return provider.ProviderInfo{}, err
 	}
@@ -85,8 +156,16 @@ func (ig *InstanceGroup) Init(ctx context.Context, logger hclog.Logger, settings
 	//nolint:contextcheck
 	ig.startSessionTicketRefresher()
 
+	//nolint:contextcheck
+	ig.startResumedDeployments(deploymentsToResume)
+
+	id := ig.Settings.Pool
+	if ig.Settings.InstanceGroupID != "" {
+		id += "/" + ig.Settings.InstanceGroupID
+	}
+
 	return provider.ProviderInfo{
-		ID:      ig.Settings.Pool,
+		ID:      id,
 		MaxSize: *ig.Settings.MaxInstances,
 	}, nil
 }
@@ -96,19 +175,38 @@ func (ig *InstanceGroup) Shutdown(_ context.Context) error {
 	ig.collectorShutdownTrigger <- struct{}{}
 	ig.sessionTicketRefresherShutdownTrigger <- struct{}{}
 
+	if ig.resumedDeploymentsCancel != nil {
+		ig.resumedDeploymentsCancel()
+	}
+
 	ig.collectorWaitGroup.Wait()
 	ig.sessionTicketRefresherWaitGroup.Wait()
+	ig.resumedDeploymentsWaitGroup.Wait()
 
 	return nil
 }
 
 // Increase implements provider.InstanceGroup.
 func (ig *InstanceGroup) Increase(ctx context.Context, count int) (int, error) {
+	if err := ig.checkTemplateNodeAvailability(ctx); err != nil {
+		return 0, err
+	}
+
 	template, err := ig.getProxmoxVM(ctx, *ig.Settings.TemplateID)
 	if err != nil {
 		return 0, fmt.Errorf("failed to find template with id='%d': %w", *ig.Settings.TemplateID, err)
 	}
 
+	count, err = ig.capInstancesByStorage(ctx, template, count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check storage capacity: %w", err)
+	}
+
+	count, err = ig.capInstancesByNodeResources(ctx, template, count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check node resources: %w", err)
+	}
+
 	var (
 		errorGroup = new(errgroup.Group)
 
@@ -150,12 +248,12 @@ func (ig *InstanceGroup) Update(ctx context.Context, update func(instance string
 	ig.instanceCloningMu.Lock()
 	defer ig.instanceCloningMu.Unlock()
 
-	pool, err := ig.getProxmoxPool(ctx)
+	members, err := ig.getPoolMembers(ctx)
 	if err != nil {
 		return err
 	}
 
-	for _, member := range pool.Members {
+	for _, member := range members {
 		if !ig.isProxmoxResourceAnInstance(member) {
 			continue
 		}
@@ -167,6 +265,10 @@ func (ig *InstanceGroup) Update(ctx context.Context, update func(instance string
 			state = provider.StateCreating
 		case ig.Settings.InstanceNameRunning:
 			state = provider.StateRunning
+
+			if ig.isInstanceDraining(member.VMID) {
+				state = provider.StateDeleting
+			}
 		case ig.Settings.InstanceNameRemoving:
 			state = provider.StateDeleting
 		default:
@@ -186,32 +288,32 @@ func (ig *InstanceGroup) ConnectInfo(ctx context.Context, instance string) (prov
 		return provider.ConnectInfo{}, fmt.Errorf("failed to parse instance name '%s': %w", instance, err)
 	}
 
-	vm, err := ig.getProxmoxVM(ctx, VMID)
+	member, err := ig.getPoolMember(ctx, VMID)
 	if err != nil {
 		return provider.ConnectInfo{}, fmt.Errorf("failed to retrieve instance vmid='%d': %w", VMID, err)
 	}
 
-	networkInterfaces, err := vm.AgentGetNetworkIFaces(ctx)
+	addresses, err := ig.getInstanceAddresses(ctx, member.Node, member.VMID)
 	if err != nil {
-		return provider.ConnectInfo{}, fmt.Errorf("failed to retrieve instance vmid='%d' interfaces: %w", VMID, err)
+		return provider.ConnectInfo{}, err
 	}
 
-	internalAddress, externalAddress, err := determineAddresses(networkInterfaces, ig.InstanceNetworkInterface, ig.InstanceNetworkProtocol)
+	connectorConfig, err := ig.getInstanceConnectorConfig(ctx, member.Node, member.VMID)
 	if err != nil {
 		return provider.ConnectInfo{}, err
 	}
 
 	return provider.ConnectInfo{
 		ID:              instance,
-		InternalAddr:    internalAddress,
-		ExternalAddr:    externalAddress,
-		ConnectorConfig: ig.FleetingSettings.ConnectorConfig,
+		InternalAddr:    addresses.internal,
+		ExternalAddr:    addresses.external,
+		ConnectorConfig: connectorConfig,
 	}, nil
 }
 
 // Decrease implements provider.InstanceGroup.
 func (ig *InstanceGroup) Decrease(ctx context.Context, instancesToRemove []string) ([]string, error) {
-	pool, err := ig.getProxmoxPool(ctx)
+	members, err := ig.getPoolMembers(ctx)
 	if err != nil {
 		return []string{}, err
 	}
@@ -223,7 +325,7 @@ func (ig *InstanceGroup) Decrease(ctx context.Context, instancesToRemove []strin
 		succeededMu = new(sync.Mutex)
 	)
 
-	for _, member := range pool.Members {
+	for _, member := range members {
 		member := member
 
 		if !ig.isProxmoxResourceAnInstance(member) {
@@ -1,10 +1,13 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 
+	hclog "github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/fleeting/fleeting/provider"
 )
 
 func TestUnmarshallingPluginSettings(t *testing.T) {
@@ -17,3 +20,20 @@ func TestUnmarshallingPluginSettings(t *testing.T) {
 	require.Equal(t, "sample_url", instance.Settings.URL)
 	require.Equal(t, 5, *instance.Settings.TemplateID)
 }
+
+func TestInstanceGroup_Init_instancePasswordWithoutUsername(t *testing.T) {
+	instance := InstanceGroup{
+		Settings: Settings{
+			URL:                 sampleURL,
+			CredentialsFilePath: sampleCredentialsPath,
+			Pool:                samplePool,
+			Storage:             sampleStorage,
+			TemplateID:          &sampleTemplateID,
+			MaxInstances:        &sampleMaxInstances,
+			InstancePassword:    true,
+		},
+	}
+
+	_, err := instance.Init(context.Background(), hclog.NewNullLogger(), provider.Settings{})
+	require.ErrorIs(t, err, ErrSettingInvalidParameter)
+}
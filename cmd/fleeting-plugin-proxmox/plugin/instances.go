@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,41 +14,70 @@ import (
 )
 
 const (
-	proxmoxTaskWaitInterval  = 10 * time.Second
 	proxmoxTaskWaitTimeout   = 5 * time.Minute
 	proxmoxAgentStartTimeout = 2 * time.Minute
 )
 
 var ErrCloneVMWithoutConfiguredStorage = errors.New("attempted to clone a VM without configured storage")
 
+// Prefix of description set on cloned instances, followed by instance group ID.
+// Tags can't be set on clone and the clone is locked until it finishes, so description identifies owner in the meantime.
+const instanceGroupDescriptionPrefix = "fleeting-plugin-proxmox instance_group_id="
+
 func (ig *InstanceGroup) deployInstance(ctx context.Context, template *proxmox.VirtualMachine, cloneMu *sync.Mutex) (int, error) {
 	VMID, task, err := ig.cloneTemplate(ctx, template, cloneMu)
 
 	if err == nil {
 		ig.log.Info("Deploying new instance", "vmid", VMID)
+		ig.journalSet(VMID, JournalOperationDeploy, string(task.UPID))
 
-		err = task.Wait(ctx, proxmoxTaskWaitInterval, proxmoxTaskWaitTimeout)
+		err = waitForTask(ctx, task, proxmoxTaskWaitTimeout)
+		if err != nil {
+			ig.journalRemove(VMID)
+		}
 	}
 
 	if err != nil {
 		return VMID, fmt.Errorf("failed to deploy instance: %w", err)
 	}
 
-	vm, err := ig.getProxmoxVM(ctx, VMID)
+	return VMID, ig.finishInstanceDeployment(ctx, VMID)
+}
+
+// Starts and configures cloned instance, then marks it as running or for removal if anything failed.
+func (ig *InstanceGroup) finishInstanceDeployment(ctx context.Context, vmid int) error {
+	vm, err := ig.getProxmoxVM(ctx, vmid)
 	if err != nil {
-		return VMID, fmt.Errorf("failed to find newly deployed instance vmid='%d': %w", VMID, err)
+		return fmt.Errorf("failed to find newly deployed instance vmid='%d': %w", vmid, err)
 	}
 
 	// Start, configure etc.
 	err = func() error {
-		// Start the VM
-		task, err := vm.Start(ctx)
-		if err == nil {
-			err = task.Wait(ctx, proxmoxTaskWaitInterval, proxmoxTaskWaitTimeout)
+		// Claim the instance before anything else
+		if err := ig.stampInstanceOwnership(ctx, vm); err != nil {
+			return err
 		}
 
-		if err != nil {
-			return fmt.Errorf("failed to start newly deployed instance: %w", err)
+		// Network device is configured before boot so the instance gets address on the right network
+		if err := ig.configureInstanceNetwork(ctx, vm); err != nil {
+			return fmt.Errorf("failed to configure network on newly deployed instance: %w", err)
+		}
+
+		// Firewall must be in place before the instance gets network access
+		if err := ig.configureInstanceFirewall(ctx, vm); err != nil {
+			return fmt.Errorf("failed to configure firewall on newly deployed instance: %w", err)
+		}
+
+		// Start the VM, it might be already running if deployment was resumed
+		if !vm.IsRunning() {
+			task, err := vm.Start(ctx)
+			if err == nil {
+				err = waitForTask(ctx, task, proxmoxTaskWaitTimeout)
+			}
+
+			if err != nil {
+				return fmt.Errorf("failed to start newly deployed instance: %w", err)
+			}
 		}
 
 		// Wait for agent to start
@@ -54,14 +85,37 @@ func (ig *InstanceGroup) deployInstance(ctx context.Context, template *proxmox.V
 			return fmt.Errorf("failed when waiting for qemu agent to start on newly deployed instance: %w", err)
 		}
 
+		// Wait for DHCP to finish, so the address is known before instance is reported as running
+		if err := ig.waitForInstanceAddresses(ctx, vm); err != nil {
+			return fmt.Errorf("newly deployed instance did not get an address: %w", err)
+		}
+
+		// Detect OS and set generated password before the instance is reported as running
+		if err := ig.configureInstanceConnector(ctx, vm); err != nil {
+			return fmt.Errorf("failed to configure connector of newly deployed instance: %w", err)
+		}
+
+		// Wait for the instance to pass readiness checks
+		if err := ig.waitForInstanceReadiness(ctx, vm); err != nil {
+			return fmt.Errorf("newly deployed instance did not become ready: %w", err)
+		}
+
+		// Host keys are recorded once the instance is ready, as they might be generated on first boot (e.g. by
+		// cloud-init), but before it is reported as running, so connections can be verified
+		if err := ig.recordInstanceHostKeys(ctx, vm); err != nil {
+			return fmt.Errorf("failed to record ssh host keys of newly deployed instance: %w", err)
+		}
+
 		return nil
 	}()
 
 	newInstanceName := ig.Settings.InstanceNameRunning
 
 	if err != nil {
-		ig.log.Error("instance deployment failed, marking for removal", "vmid", VMID, "err", err)
+		ig.log.Error("instance deployment failed, marking for removal", "vmid", vmid, "err", err)
 		newInstanceName = ig.Settings.InstanceNameRemoving
+		ig.journalSet(vmid, JournalOperationRemove, "")
+		ig.forgetInstance(vmid)
 	}
 
 	_, renameErr := vm.Config(ctx, proxmox.VirtualMachineOption{
@@ -69,15 +123,19 @@ func (ig *InstanceGroup) deployInstance(ctx context.Context, template *proxmox.V
 		Value: newInstanceName,
 	})
 
+	ig.invalidateInventory()
+
 	if renameErr != nil {
-		ig.log.Error("failed to rename instance", "vmid", VMID, "err", renameErr)
+		ig.log.Error("failed to rename instance", "vmid", vmid, "err", renameErr)
 	}
 
 	if err != nil {
-		return VMID, fmt.Errorf("failed to configure instance, marked for removal due to: %w", err)
+		return fmt.Errorf("failed to configure instance, marked for removal due to: %w", err)
 	}
 
-	return VMID, nil
+	ig.journalRemove(vmid)
+
+	return nil
 }
 
 func (ig *InstanceGroup) cloneTemplate(ctx context.Context, template *proxmox.VirtualMachine, cloneMu *sync.Mutex) (int, *proxmox.Task, error) {
@@ -90,6 +148,7 @@ func (ig *InstanceGroup) cloneTemplate(ctx context.Context, template *proxmox.Vi
 	defer cloneMu.Unlock()
 
 	VMID, task, err := template.Clone(ctx, cloneOptions)
+	ig.invalidateInventory()
 	if err != nil {
 		return -1, nil, fmt.Errorf("failed to clone the template: %w", err)
 	}
@@ -113,28 +172,36 @@ func (ig *InstanceGroup) getTemplateCloneOptions(template *proxmox.VirtualMachin
 		cloneOptions.Full = 0
 	}
 
+	if ig.Settings.InstanceGroupID != "" {
+		cloneOptions.Description = instanceGroupDescriptionPrefix + strings.ToLower(ig.Settings.InstanceGroupID)
+	}
+
 	return cloneOptions, nil
 }
 
 func (ig *InstanceGroup) markStaleInstancesForRemoval(ctx context.Context) error {
-	pool, err := ig.getProxmoxPool(ctx)
+	members, err := ig.getPoolMembers(ctx)
 	if err != nil {
 		return err
 	}
 
-	instancesToMarkForRemoval := make([]*proxmox.ClusterResource, 0, len(pool.Members))
+	instancesToMarkForRemoval := make([]*proxmox.ClusterResource, 0, len(members))
 
-	for _, member := range pool.Members {
+	for _, member := range members {
 		member := member
 
-		if !ig.isProxmoxResourceAnInstance(member) {
+		if member.Name != ig.Settings.InstanceNameCreating {
 			continue
 		}
 
-		if member.Name != ig.Settings.InstanceNameCreating {
+		if !ig.isProxmoxResourceAnInstance(member) && !ig.claimUntaggedInstance(ctx, &member) {
 			continue
 		}
 
+		if entry, ok := ig.journal.get(int(member.VMID)); ok && entry.Operation == JournalOperationDeploy {
+			continue // Deployment will be resumed
+		}
+
 		ig.log.Info("Found stale instance, marking for removal", "name", member.Name, "vmid", member.VMID, "node", member.Node)
 		instancesToMarkForRemoval = append(instancesToMarkForRemoval, &member)
 	}
@@ -159,6 +226,9 @@ func (ig *InstanceGroup) markInstancesForRemoval(ctx context.Context, instances
 		errorGroup.Go(func() error {
 			log := ig.log.With("name", instance.Name, "vmid", instance.VMID, "node", instance.Node)
 
+			ig.journalSet(int(instance.VMID), JournalOperationRemove, "")
+			ig.forgetInstance(int(instance.VMID))
+
 			vm, err := ig.getProxmoxVMOnNode(ctx, int(instance.VMID), instance.Node)
 			if err != nil {
 				log.Error("Failed to mark instance for removal", "err", err)
@@ -171,7 +241,7 @@ func (ig *InstanceGroup) markInstancesForRemoval(ctx context.Context, instances
 			})
 
 			if err == nil {
-				err = task.Wait(ctx, proxmoxTaskWaitInterval, proxmoxTaskWaitTimeout)
+				err = waitForTask(ctx, task, proxmoxTaskWaitTimeout)
 			}
 
 			if err != nil {
@@ -183,7 +253,11 @@ func (ig *InstanceGroup) markInstancesForRemoval(ctx context.Context, instances
 		})
 	}
 
-	if err := errorGroup.Wait(); err != nil {
+	err := errorGroup.Wait()
+
+	ig.invalidateInventory()
+
+	if err != nil {
 		ig.instanceCollectionTrigger <- struct{}{}
 		return fmt.Errorf("failed to mark one or more instances for removal: %w", err)
 	}
@@ -193,6 +267,85 @@ func (ig *InstanceGroup) markInstancesForRemoval(ctx context.Context, instances
 	return nil
 }
 
+// Forgets everything recorded about the instance, so nothing is reused when its VMID is.
+func (ig *InstanceGroup) forgetInstance(vmid int) {
+	ig.forgetInstanceAddresses(uint64(vmid))
+	ig.forgetInstanceConnectorConfig(uint64(vmid))
+	ig.knownHostsRemove(vmid)
+}
+
 func (ig *InstanceGroup) isProxmoxResourceAnInstance(member proxmox.ClusterResource) bool {
-	return member.Type == "qemu" && member.VMID != uint64(*ig.Settings.TemplateID)
+	return member.Type == "qemu" && member.VMID != uint64(*ig.Settings.TemplateID) && ig.isProxmoxResourceOwned(member)
+}
+
+// Checks if resource belongs to this instance group, always true when instance group ID is not configured.
+func (ig *InstanceGroup) isProxmoxResourceOwned(member proxmox.ClusterResource) bool {
+	if ig.Settings.InstanceGroupID == "" {
+		return true
+	}
+
+	return slices.Contains(splitProxmoxTags(member.Tags), strings.ToLower(ig.Settings.InstanceGroupID))
+}
+
+// Stamps instance with instance group ID tag so other plugin instances sharing the pool ignore it.
+func (ig *InstanceGroup) stampInstanceOwnership(ctx context.Context, vm *proxmox.VirtualMachine) error {
+	if ig.Settings.InstanceGroupID == "" {
+		return nil
+	}
+
+	task, err := vm.AddTag(ctx, strings.ToLower(ig.Settings.InstanceGroupID))
+	defer ig.invalidateInventory()
+
+	if errors.Is(err, proxmox.ErrNoop) {
+		return nil
+	}
+
+	if err == nil {
+		err = waitForTask(ctx, task, proxmoxTaskWaitTimeout)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to tag instance with instance group id='%s': %w", ig.Settings.InstanceGroupID, err)
+	}
+
+	return nil
+}
+
+// Tags instance which was cloned by this instance group but not tagged yet, e.g. because plugin crashed during cloning.
+// Reports whether the instance belongs to this instance group and was tagged.
+func (ig *InstanceGroup) claimUntaggedInstance(ctx context.Context, member *proxmox.ClusterResource) bool {
+	if ig.Settings.InstanceGroupID == "" || member.Type != "qemu" || member.VMID == uint64(*ig.Settings.TemplateID) {
+		return false
+	}
+
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/config", member.Node, member.VMID)
+
+	config := proxmox.VirtualMachineConfig{}
+	if err := ig.proxmox.Get(ctx, path, &config); err != nil {
+		ig.log.Warn("failed to check owner of untagged instance", "vmid", member.VMID, "err", err)
+		return false
+	}
+
+	if strings.TrimSpace(config.Description) != instanceGroupDescriptionPrefix+strings.ToLower(ig.Settings.InstanceGroupID) {
+		return false
+	}
+
+	tags := append(splitProxmoxTags(member.Tags), strings.ToLower(ig.Settings.InstanceGroupID))
+	if err := ig.proxmox.Put(ctx, path, map[string]string{"tags": strings.Join(tags, ";")}, nil); err != nil {
+		ig.log.Warn("failed to tag instance with instance group id", "vmid", member.VMID, "err", err)
+		return false
+	}
+
+	ig.invalidateInventory()
+
+	member.Tags = strings.Join(tags, ";")
+
+	return true
+}
+
+// Splits Proxmox VE tags string, tags are stored lowercase and can be separated with semicolons, commas or spaces.
+func splitProxmoxTags(tags string) []string {
+	return strings.FieldsFunc(strings.ToLower(tags), func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	})
 }
@@ -1,8 +1,10 @@
 package plugin
 
 import (
+	"context"
 	"testing"
 
+	hclog "github.com/hashicorp/go-hclog"
 	"github.com/luthermonson/go-proxmox"
 	"github.com/stretchr/testify/require"
 )
@@ -67,3 +69,108 @@ func TestInstanceGroup_templateCloneOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestInstanceGroup_isProxmoxResourceAnInstance(t *testing.T) {
+	type testCase struct {
+		name            string
+		instanceGroupID string
+		resource        proxmox.ClusterResource
+		expected        bool
+	}
+
+	testCases := []testCase{
+		{
+			name:     "Template",
+			resource: proxmox.ClusterResource{Type: "qemu", VMID: uint64(sampleTemplateID)},
+			expected: false,
+		},
+		{
+			name:     "Container",
+			resource: proxmox.ClusterResource{Type: "lxc", VMID: 100},
+			expected: false,
+		},
+		{
+			name:     "VM without instance group id",
+			resource: proxmox.ClusterResource{Type: "qemu", VMID: 100},
+			expected: true,
+		},
+		{
+			name:            "VM with matching tag",
+			instanceGroupID: "Manager-1",
+			resource:        proxmox.ClusterResource{Type: "qemu", VMID: 100, Tags: "runners;manager-1"},
+			expected:        true,
+		},
+		{
+			name:            "VM with other instance group tag",
+			instanceGroupID: "manager-1",
+			resource:        proxmox.ClusterResource{Type: "qemu", VMID: 100, Tags: "runners;manager-2"},
+			expected:        false,
+		},
+		{
+			name:            "Untagged VM",
+			instanceGroupID: "manager-1",
+			resource:        proxmox.ClusterResource{Type: "qemu", VMID: 100},
+			expected:        false,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ig := InstanceGroup{
+				Settings: Settings{
+					TemplateID:      &sampleTemplateID,
+					InstanceGroupID: testCase.instanceGroupID,
+				},
+			}
+
+			require.Equal(t, testCase.expected, ig.isProxmoxResourceAnInstance(testCase.resource))
+		})
+	}
+}
+
+func Test_splitProxmoxTags(t *testing.T) {
+	require.Empty(t, splitProxmoxTags(""))
+	require.Equal(t, []string{"a", "b", "c", "d"}, splitProxmoxTags("a;B,c d"))
+}
+
+func TestInstanceGroup_templateCloneOptions_instanceGroupID(t *testing.T) {
+	ig := InstanceGroup{
+		Settings: Settings{
+			InstanceGroupID: "Runners",
+		},
+	}
+
+	result, err := ig.getTemplateCloneOptions(&proxmox.VirtualMachine{Template: true})
+	require.NoError(t, err)
+	require.Equal(t, "fleeting-plugin-proxmox instance_group_id=runners", result.Description)
+}
+
+func TestInstanceGroup_claimUntaggedInstance(t *testing.T) {
+	client, requests := newRecordingServer(t, map[string]string{
+		"/nodes/pve1/qemu/101/config": `{"description":"fleeting-plugin-proxmox instance_group_id=runners\n"}`,
+		"/nodes/pve1/qemu/102/config": `{"description":"fleeting-plugin-proxmox instance_group_id=other\n"}`,
+		"/nodes/pve1/qemu/103/config": `{}`,
+	})
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+		Settings: Settings{
+			InstanceGroupID: "runners",
+			TemplateID:      &sampleTemplateID,
+		},
+	}
+
+	// Cloned by this instance group
+	member := &proxmox.ClusterResource{Type: "qemu", Node: "pve1", VMID: 101, Tags: "linux"}
+	require.True(t, ig.claimUntaggedInstance(context.Background(), member))
+	require.Equal(t, "linux;runners", member.Tags)
+	require.True(t, ig.isProxmoxResourceOwned(*member))
+
+	// Cloned by another instance group or not by the plugin at all
+	require.False(t, ig.claimUntaggedInstance(context.Background(), &proxmox.ClusterResource{Type: "qemu", Node: "pve1", VMID: 102}))
+	require.False(t, ig.claimUntaggedInstance(context.Background(), &proxmox.ClusterResource{Type: "qemu", Node: "pve1", VMID: 103}))
+
+	require.Equal(t, []recordedRequest{
+		{Method: "PUT", Path: "/nodes/pve1/qemu/101/config", Body: map[string]any{"tags": "linux;runners"}},
+	}, requests())
+}
@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// How long listed cluster resources are reused before listing them again.
+const inventoryTTL = 5 * time.Second
+
+// Short-lived cache of cluster resources shared by all operations to limit calls to Proxmox VE API.
+type inventory struct {
+	mu        sync.Mutex
+	resources proxmox.ClusterResources
+	fetchedAt time.Time
+}
+
+// Returns all cluster resources visible for the user, listing them again if cached ones are too old.
+func (ig *InstanceGroup) getClusterResources(ctx context.Context) (proxmox.ClusterResources, error) {
+	// Mutex is held during listing so concurrent callers share a single API call
+	ig.inventory.mu.Lock()
+	defer ig.inventory.mu.Unlock()
+
+	if ig.inventory.resources != nil && time.Since(ig.inventory.fetchedAt) < inventoryTTL {
+		return ig.inventory.resources, nil
+	}
+
+	resources := proxmox.ClusterResources{}
+	if err := ig.proxmox.Get(ctx, "/cluster/resources", &resources); err != nil {
+		return nil, fmt.Errorf("failed to list cluster resources: %w", err)
+	}
+
+	ig.inventory.resources = resources
+	ig.inventory.fetchedAt = time.Now()
+
+	return resources, nil
+}
+
+// Returns resources which are members of the configured pool.
+func (ig *InstanceGroup) getPoolMembers(ctx context.Context) ([]proxmox.ClusterResource, error) {
+	resources, err := ig.getClusterResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]proxmox.ClusterResource, 0, len(resources))
+
+	for _, resource := range resources {
+		if resource.Pool == ig.Settings.Pool {
+			members = append(members, *resource)
+		}
+	}
+
+	return members, nil
+}
+
+// Finds VM with given ID in the configured pool.
+func (ig *InstanceGroup) getPoolMember(ctx context.Context, vmid int) (*proxmox.ClusterResource, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		members, err := ig.getPoolMembers(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, member := range members {
+			if member.Type == "qemu" && member.VMID == uint64(vmid) {
+				return &member, nil
+			}
+		}
+
+		// VM might be created after resources were listed, so try again with fresh list
+		ig.invalidateInventory()
+	}
+
+	return nil, ErrNotFound
+}
+
+// Drops cached resources, must be called after any change to instances.
+func (ig *InstanceGroup) invalidateInventory() {
+	ig.inventory.mu.Lock()
+	defer ig.inventory.mu.Unlock()
+
+	ig.inventory.resources = nil
+}
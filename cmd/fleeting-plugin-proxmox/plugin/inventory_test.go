@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceGroup_inventory(t *testing.T) {
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/cluster/resources", r.URL.Path)
+		requests.Add(1)
+
+		_, _ = w.Write([]byte(`{"data":[
+			{"type":"node","node":"pve1","status":"online"},
+			{"type":"qemu","node":"pve1","vmid":100,"name":"fleeting-running","pool":"sample_pool"},
+			{"type":"qemu","node":"pve1","vmid":101,"name":"other","pool":"other_pool"},
+			{"type":"storage","node":"pve1","storage":"local","pool":"sample_pool"}
+		]}`))
+	}))
+	defer server.Close()
+
+	ig := InstanceGroup{
+		Settings: Settings{
+			Pool: samplePool,
+		},
+		proxmox: proxmox.NewClient(server.URL),
+	}
+
+	members, err := ig.getPoolMembers(context.Background())
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	require.Equal(t, uint64(100), members[0].VMID)
+	require.Equal(t, "pve1", members[0].Node)
+
+	// Cached
+	member, err := ig.getPoolMember(context.Background(), 100)
+	require.NoError(t, err)
+	require.Equal(t, "fleeting-running", member.Name)
+	require.Equal(t, int32(1), requests.Load())
+
+	// Invalidated
+	ig.invalidateInventory()
+
+	_, err = ig.getClusterResources(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(2), requests.Load())
+
+	// Missing VM is looked up once more with fresh list
+	_, err = ig.getPoolMember(context.Background(), 101)
+	require.ErrorIs(t, err, ErrNotFound)
+	require.Equal(t, int32(3), requests.Load())
+}
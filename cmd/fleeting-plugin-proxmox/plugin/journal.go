@@ -0,0 +1,312 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// Operation recorded in the state journal.
+type JournalOperation string
+
+const (
+	// Instance was cloned but it is not running yet.
+	JournalOperationDeploy JournalOperation = "deploy"
+
+	// Instance was selected for removal but it was not deleted yet.
+	JournalOperationRemove JournalOperation = "remove"
+)
+
+// In-flight operation on a single instance.
+type journalEntry struct {
+	VMID      int              `json:"vmid"`
+	Operation JournalOperation `json:"operation"`
+	TaskUPID  string           `json:"task_upid,omitempty"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// On-disk journal of in-flight operations used to recover after a crash.
+//
+// Methods of a nil journal are no-ops so that callers don't need to check whether journal is enabled.
+type journal struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[int]journalEntry
+}
+
+// Opens journal at given path, an empty path disables the journal.
+func openJournal(path string) (*journal, error) {
+	if path == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	j := &journal{
+		path:    path,
+		entries: map[int]journalEntry{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state journal from path='%s': %w", path, err)
+	}
+
+	entries := []journalEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode state journal from path='%s': %w", path, err)
+	}
+
+	for _, entry := range entries {
+		j.entries[entry.VMID] = entry
+	}
+
+	return j, nil
+}
+
+// Records operation for given instance, replacing previous one.
+func (j *journal) set(vmid int, operation JournalOperation, taskUPID string) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries[vmid] = journalEntry{
+		VMID:      vmid,
+		Operation: operation,
+		TaskUPID:  taskUPID,
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	return j.save()
+}
+
+// Forgets any operation for given instance.
+func (j *journal) remove(vmid int) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.entries[vmid]; !ok {
+		return nil
+	}
+
+	delete(j.entries, vmid)
+
+	return j.save()
+}
+
+// Returns operation recorded for given instance.
+func (j *journal) get(vmid int) (journalEntry, bool) {
+	if j == nil {
+		return journalEntry{}, false
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry, ok := j.entries[vmid]
+
+	return entry, ok
+}
+
+// Returns all recorded operations ordered by VMID.
+func (j *journal) list() []journalEntry {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.sortedEntries()
+}
+
+func (j *journal) sortedEntries() []journalEntry {
+	entries := make([]journalEntry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a, b journalEntry) int {
+		return a.VMID - b.VMID
+	})
+
+	return entries
+}
+
+// Atomically writes the journal to disk, must be called with the mutex held.
+func (j *journal) save() error {
+	data, err := json.Marshal(j.sortedEntries())
+	if err != nil {
+		return fmt.Errorf("failed to encode state journal: %w", err)
+	}
+
+	if err := writeFileAtomically(j.path, data); err != nil {
+		return fmt.Errorf("failed to save state journal to path='%s': %w", j.path, err)
+	}
+
+	return nil
+}
+
+// Records operation in the state journal, failures are only logged as the journal is best-effort.
+func (ig *InstanceGroup) journalSet(vmid int, operation JournalOperation, taskUPID string) {
+	if err := ig.journal.set(vmid, operation, taskUPID); err != nil {
+		ig.log.Error("failed to record operation in state journal", "vmid", vmid, "operation", operation, "err", err)
+	}
+}
+
+// Removes operation from the state journal, failures are only logged as the journal is best-effort.
+func (ig *InstanceGroup) journalRemove(vmid int) {
+	if err := ig.journal.remove(vmid); err != nil {
+		ig.log.Error("failed to remove operation from state journal", "vmid", vmid, "err", err)
+	}
+}
+
+// Resumes removals recorded in the state journal and returns deployments that can be resumed.
+func (ig *InstanceGroup) recoverFromJournal(ctx context.Context) ([]journalEntry, error) {
+	entries := ig.journal.list()
+	if len(entries) < 1 {
+		return nil, nil
+	}
+
+	members, err := ig.getPoolMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		deploymentsToResume = []journalEntry{}
+		instancesToRemove   = []*proxmox.ClusterResource{}
+	)
+
+	for _, entry := range entries {
+		index := slices.IndexFunc(members, func(member proxmox.ClusterResource) bool {
+			// Ownership is not checked as resumed deployment might not be tagged yet
+			return member.Type == "qemu" && member.VMID == uint64(entry.VMID)
+		})
+
+		if index < 0 {
+			ig.log.Info("instance from state journal no longer exists", "vmid", entry.VMID, "operation", entry.Operation)
+			ig.journalRemove(entry.VMID)
+
+			continue
+		}
+
+		member := members[index]
+
+		switch entry.Operation {
+		case JournalOperationDeploy:
+			if member.Name != ig.Settings.InstanceNameCreating {
+				ig.journalRemove(entry.VMID)
+				continue
+			}
+
+			ig.log.Info("resuming instance deployment from state journal", "vmid", entry.VMID)
+			deploymentsToResume = append(deploymentsToResume, entry)
+		case JournalOperationRemove:
+			if member.Name == ig.Settings.InstanceNameRemoving {
+				continue // Collector will take care of it
+			}
+
+			ig.log.Info("resuming instance removal from state journal", "vmid", entry.VMID)
+			instancesToRemove = append(instancesToRemove, &member)
+		default:
+			ig.log.Warn("unknown operation in state journal, ignoring", "vmid", entry.VMID, "operation", entry.Operation)
+			ig.journalRemove(entry.VMID)
+		}
+	}
+
+	if len(instancesToRemove) > 0 {
+		if err := ig.markInstancesForRemoval(ctx, instancesToRemove...); err != nil {
+			return nil, fmt.Errorf("failed to resume instance removals from state journal: %w", err)
+		}
+	}
+
+	return deploymentsToResume, nil
+}
+
+func (ig *InstanceGroup) startResumedDeployments(entries []journalEntry) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ig.resumedDeploymentsCancel = cancel
+
+	for _, entry := range entries {
+		ig.resumedDeploymentsWaitGroup.Add(1)
+
+		go func(entry journalEntry) {
+			defer ig.resumedDeploymentsWaitGroup.Done()
+
+			if err := ig.resumeDeployment(ctx, entry); err != nil {
+				ig.log.Error("failed to resume instance deployment", "vmid", entry.VMID, "err", err)
+
+				// Deployment is resumed again on next start if the plugin is shutting down
+				if ctx.Err() == nil {
+					ig.abandonResumedDeployment(ctx, entry.VMID)
+				}
+
+				return
+			}
+
+			ig.log.Info("successfully resumed instance deployment", "vmid", entry.VMID)
+		}(entry)
+	}
+}
+
+func (ig *InstanceGroup) resumeDeployment(ctx context.Context, entry journalEntry) error {
+	if entry.TaskUPID != "" {
+		task := proxmox.NewTask(proxmox.UPID(entry.TaskUPID), ig.proxmox)
+
+		if err := waitForTask(ctx, task, proxmoxTaskWaitTimeout); err != nil {
+			return fmt.Errorf("failed to wait for clone task: %w", err)
+		}
+	}
+
+	return ig.finishInstanceDeployment(ctx, entry.VMID)
+}
+
+// Marks instance whose deployment could not be resumed for removal, so it is not left in creating state forever.
+func (ig *InstanceGroup) abandonResumedDeployment(ctx context.Context, vmid int) {
+	// Failed deployment might have been already marked for removal
+	if entry, ok := ig.journal.get(vmid); ok && entry.Operation != JournalOperationDeploy {
+		return
+	}
+
+	member, err := ig.getPoolMember(ctx, vmid)
+	if errors.Is(err, ErrNotFound) {
+		ig.journalRemove(vmid)
+		return
+	}
+
+	// Removal is resumed on next start if the instance can't be renamed now
+	ig.journalSet(vmid, JournalOperationRemove, "")
+	ig.forgetInstance(vmid)
+
+	if err == nil {
+		config := map[string]string{"name": ig.Settings.InstanceNameRemoving}
+		err = ig.proxmox.Put(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", member.Node, vmid), config, nil)
+	}
+
+	ig.invalidateInventory()
+
+	if err != nil {
+		ig.log.Error("failed to mark instance with failed resumed deployment for removal", "vmid", vmid, "err", err)
+		return
+	}
+
+	ig.instanceCollectionTrigger <- struct{}{}
+}
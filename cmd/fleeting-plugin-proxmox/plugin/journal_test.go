@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal_disabled(t *testing.T) {
+	j, err := openJournal("")
+	require.NoError(t, err)
+	require.Nil(t, j)
+
+	require.NoError(t, j.set(100, JournalOperationDeploy, ""))
+	require.NoError(t, j.remove(100))
+	require.Empty(t, j.list())
+
+	_, ok := j.get(100)
+	require.False(t, ok)
+}
+
+func TestJournal_persistence(t *testing.T) {
+	journalPath := path.Join(t.TempDir(), "journal.json")
+
+	j, err := openJournal(journalPath)
+	require.NoError(t, err)
+	require.Empty(t, j.list())
+
+	require.NoError(t, j.set(102, JournalOperationRemove, ""))
+	require.NoError(t, j.set(101, JournalOperationDeploy, "UPID:pve:0001:0002:0003:qmclone:20:root@pam:"))
+	require.NoError(t, j.set(103, JournalOperationDeploy, ""))
+	require.NoError(t, j.remove(103))
+	require.NoError(t, j.remove(104))
+
+	reopened, err := openJournal(journalPath)
+	require.NoError(t, err)
+
+	entries := reopened.list()
+	require.Len(t, entries, 2)
+	require.Equal(t, 101, entries[0].VMID)
+	require.Equal(t, JournalOperationDeploy, entries[0].Operation)
+	require.Equal(t, "UPID:pve:0001:0002:0003:qmclone:20:root@pam:", entries[0].TaskUPID)
+	require.Equal(t, 102, entries[1].VMID)
+	require.Equal(t, JournalOperationRemove, entries[1].Operation)
+
+	entry, ok := reopened.get(102)
+	require.True(t, ok)
+	require.Equal(t, JournalOperationRemove, entry.Operation)
+}
+
+func TestJournal_malformed(t *testing.T) {
+	journalPath := path.Join(t.TempDir(), "journal.json")
+
+	require.NoError(t, os.WriteFile(journalPath, []byte(`{"vmid":`), 0o600))
+
+	_, err := openJournal(journalPath)
+	require.Error(t, err)
+}
+
+func TestInstanceGroup_startResumedDeployments_failed(t *testing.T) {
+	client, requests := newRecordingServer(t, map[string]string{
+		fmt.Sprintf("/nodes/pve1/tasks/%s/status", sampleUPID): fmt.Sprintf(`{"upid":"%s","node":"pve1","status":"stopped","exitstatus":"clone failed"}`, sampleUPID),
+		"/cluster/resources": `[{"type":"qemu","node":"pve1","vmid":100,"name":"fleeting-creating","pool":"sample_pool"}]`,
+	})
+
+	j, err := openJournal(path.Join(t.TempDir(), "journal.json"))
+	require.NoError(t, err)
+	require.NoError(t, j.set(100, JournalOperationDeploy, sampleUPID))
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+		journal: j,
+		Settings: Settings{
+			Pool:                 samplePool,
+			InstanceNameCreating: "fleeting-creating",
+			InstanceNameRemoving: "fleeting-removing",
+		},
+		instanceCollectionTrigger: make(chan struct{}, triggerChannelCapacity),
+	}
+
+	ig.startResumedDeployments(j.list())
+	ig.resumedDeploymentsWaitGroup.Wait()
+
+	// Instance is renamed, so collector removes it, and its removal is resumed if renaming didn't finish
+	require.Equal(t, []recordedRequest{
+		{Method: "PUT", Path: "/nodes/pve1/qemu/100/config", Body: map[string]any{"name": "fleeting-removing"}},
+	}, requests())
+
+	entry, ok := j.get(100)
+	require.True(t, ok)
+	require.Equal(t, JournalOperationRemove, entry.Operation)
+	require.Len(t, ig.instanceCollectionTrigger, 1)
+}
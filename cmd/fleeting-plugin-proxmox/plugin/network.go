@@ -1,17 +1,196 @@
 package plugin
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
+	"path"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/luthermonson/go-proxmox"
 )
 
-var ErrNoIPAddress = errors.New("failed to determine IP address for instance")
+const (
+	instanceAddressPollInterval = 1 * time.Second
+
+	// Recorded addresses are read again after this time, in case they changed, e.g. when DHCP lease expired.
+	instanceAddressTTL = 5 * time.Minute
+)
+
+var (
+	ErrNoIPAddress                  = errors.New("failed to determine IP address for instance")
+	ErrInvalidNetworkInterfaceMatch = errors.New("invalid network interface match")
+	ErrInvalidAddressCIDR           = errors.New("invalid address CIDR")
+)
+
+// Allow and deny lists of networks, deny entries are prefixed with "!".
+type addressCIDRs struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// Rules classifying instance's addresses as internal and external.
+type addressRules struct {
+	internal addressCIDRs
+	external addressCIDRs
+}
+
+// Parses CIDRs like "10.50.0.0/16" or "!172.17.0.0/16".
+func parseAddressCIDRs(cidrs []string) (addressCIDRs, error) {
+	parsed := addressCIDRs{}
+
+	for _, cidr := range cidrs {
+		deny := strings.HasPrefix(cidr, "!")
+
+		_, network, err := net.ParseCIDR(strings.TrimPrefix(cidr, "!"))
+		if err != nil {
+			return addressCIDRs{}, fmt.Errorf("%w: cidr='%s': %w", ErrInvalidAddressCIDR, cidr, err)
+		}
+
+		if deny {
+			parsed.deny = append(parsed.deny, network)
+		} else {
+			parsed.allow = append(parsed.allow, network)
+		}
+	}
+
+	return parsed, nil
+}
+
+// Parses internal and external address CIDRs.
+func parseAddressRules(internalCIDRs, externalCIDRs []string) (addressRules, error) {
+	internal, err := parseAddressCIDRs(internalCIDRs)
+	if err != nil {
+		return addressRules{}, err
+	}
+
+	external, err := parseAddressCIDRs(externalCIDRs)
+	if err != nil {
+		return addressRules{}, err
+	}
+
+	return addressRules{internal: internal, external: external}, nil
+}
+
+// Checks if the address is allowed, returning position of the first allow entry it belongs to.
+// Denied addresses are never allowed, without allow entries the address is allowed if it is in default range.
+func (c addressCIDRs) rank(address net.IP, inDefaultRange bool) (int, bool) {
+	for _, network := range c.deny {
+		if network.Contains(address) {
+			return 0, false
+		}
+	}
+
+	if len(c.allow) == 0 {
+		return 0, inDefaultRange
+	}
+
+	for i, network := range c.allow {
+		if network.Contains(address) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// Best address found so far, addresses with lower rank win and the first one is kept on a tie.
+type addressCandidate struct {
+	address string
+	rank    int
+}
+
+func (c *addressCandidate) offer(address string, rank int, allowed bool) {
+	if allowed && (c.address == "" || rank < c.rank) {
+		c.address = address
+		c.rank = rank
+	}
+}
+
+// Returns true for the network interface to read addresses from.
+type networkInterfaceMatcher func(networkInterface *proxmox.AgentNetworkIface) bool
+
+// Checks that given match mode and pattern can be used to find network interface.
+func checkNetworkInterfaceMatch(match NetworkInterfaceMatch, pattern string) error {
+	switch match {
+	case "", NetworkInterfaceMatchName, NetworkInterfaceMatchMAC, NetworkInterfaceMatchFirst:
+		return nil
+	case NetworkInterfaceMatchGlob:
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: pattern='%s': %w", ErrInvalidNetworkInterfaceMatch, pattern, err)
+		}
+
+		return nil
+	case NetworkInterfaceMatchRegex:
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%w: pattern='%s': %w", ErrInvalidNetworkInterfaceMatch, pattern, err)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: must be name, glob, regex, mac or first", ErrInvalidNetworkInterfaceMatch)
+	}
+}
+
+// Creates matcher for given match mode, pattern is the interface name, glob or regular expression and macAddress is used in mac mode.
+func newNetworkInterfaceMatcher(match NetworkInterfaceMatch, pattern, macAddress string) (networkInterfaceMatcher, error) {
+	if err := checkNetworkInterfaceMatch(match, pattern); err != nil {
+		return nil, err
+	}
+
+	switch match {
+	case NetworkInterfaceMatchGlob:
+		return func(networkInterface *proxmox.AgentNetworkIface) bool {
+			matches, _ := path.Match(pattern, networkInterface.Name)
+			return matches
+		}, nil
+	case NetworkInterfaceMatchRegex:
+		expression := regexp.MustCompile(pattern)
+
+		return func(networkInterface *proxmox.AgentNetworkIface) bool {
+			return expression.MatchString(networkInterface.Name)
+		}, nil
+	case NetworkInterfaceMatchMAC:
+		return func(networkInterface *proxmox.AgentNetworkIface) bool {
+			return macAddress != "" && strings.EqualFold(networkInterface.HardwareAddress, macAddress)
+		}, nil
+	case NetworkInterfaceMatchFirst:
+		return func(networkInterface *proxmox.AgentNetworkIface) bool {
+			return !isLoopbackInterface(networkInterface)
+		}, nil
+	default:
+		return matchNetworkInterfaceName(pattern), nil
+	}
+}
+
+// Returns matcher of interface with given name.
+func matchNetworkInterfaceName(name string) networkInterfaceMatcher {
+	return func(networkInterface *proxmox.AgentNetworkIface) bool {
+		return networkInterface.Name == name
+	}
+}
+
+// Checks if the interface is a loopback, i.e. it has loopback address or is named like one.
+func isLoopbackInterface(networkInterface *proxmox.AgentNetworkIface) bool {
+	if networkInterface.Name == "lo" || strings.HasPrefix(networkInterface.Name, "Loopback") {
+		return true
+	}
+
+	for _, address := range networkInterface.IPAddresses {
+		if parsedAddress := net.ParseIP(address.IPAddress); parsedAddress != nil && parsedAddress.IsLoopback() {
+			return true
+		}
+	}
+
+	return false
+}
 
 // Determines internal and external address for given interfaces.
-func determineAddresses(networkInterfaces []*proxmox.AgentNetworkIface, requestedInterface string, requestedProtocol NetworkProtocol) (string, string, error) {
-	internalIPv4, externalIPv4, internalIPv6, externalIPv6 := determinePossibleAddresses(networkInterfaces, requestedInterface)
+func determineAddresses(networkInterfaces []*proxmox.AgentNetworkIface, requestedInterface networkInterfaceMatcher, rules addressRules, requestedProtocol NetworkProtocol) (string, string, error) {
+	internalIPv4, externalIPv4, internalIPv6, externalIPv6 := determinePossibleAddresses(networkInterfaces, requestedInterface, rules)
 
 	// IPv6 (or Any)
 	if requestedProtocol == NetworkProtocolIPv6 || requestedProtocol == NetworkProtocolAny {
@@ -42,14 +221,18 @@ func determineAddresses(networkInterfaces []*proxmox.AgentNetworkIface, requeste
 }
 
 // Finds possible IPv4 and IPv6 addresses for given interfaces.
+// Private addresses are internal and other global unicast addresses are external unless rules say otherwise,
+// if more addresses are allowed then the one matching earlier CIDR wins, then the one reported first.
 //
 //nolint:nakedret,nonamedreturns
-func determinePossibleAddresses(networkInterfaces []*proxmox.AgentNetworkIface, requestedInterface string) (internalIPv4, externalIPv4, internalIPv6, externalIPv6 string) {
+func determinePossibleAddresses(networkInterfaces []*proxmox.AgentNetworkIface, requestedInterface networkInterfaceMatcher, rules addressRules) (internalIPv4, externalIPv4, internalIPv6, externalIPv6 string) {
 	for _, networkInterface := range networkInterfaces {
-		if networkInterface.Name != requestedInterface {
+		if !requestedInterface(networkInterface) {
 			continue
 		}
 
+		var internalV4, externalV4, internalV6, externalV6 addressCandidate
+
 		for _, address := range networkInterface.IPAddresses {
 			parsedAddress := net.ParseIP(address.IPAddress)
 
@@ -61,26 +244,139 @@ func determinePossibleAddresses(networkInterfaces []*proxmox.AgentNetworkIface,
 				continue
 			}
 
+			internalRank, isInternal := rules.internal.rank(parsedAddress, parsedAddress.IsPrivate())
+			externalRank, isExternal := rules.external.rank(parsedAddress, !parsedAddress.IsPrivate() && parsedAddress.IsGlobalUnicast())
+
 			if address.IPAddressType == "ipv4" {
-				if parsedAddress.IsPrivate() {
-					internalIPv4 = address.IPAddress
-				} else if parsedAddress.IsGlobalUnicast() {
-					externalIPv4 = address.IPAddress
-				}
+				internalV4.offer(address.IPAddress, internalRank, isInternal)
+				externalV4.offer(address.IPAddress, externalRank, isExternal)
 			}
 
 			if address.IPAddressType == "ipv6" {
-				if parsedAddress.IsPrivate() {
-					internalIPv6 = address.IPAddress
-				} else if parsedAddress.IsGlobalUnicast() {
-					externalIPv6 = address.IPAddress
-				}
+				internalV6.offer(address.IPAddress, internalRank, isInternal)
+				externalV6.offer(address.IPAddress, externalRank, isExternal)
 			}
 		}
 
 		// We found requested interface so we can break the loop
-		return
+		return internalV4.address, externalV4.address, internalV6.address, externalV6.address
 	}
 
 	return
 }
+
+// Determines internal and external address of the instance from its network interfaces reported by QEMU guest agent.
+func (ig *InstanceGroup) determineInstanceAddresses(ctx context.Context, node string, vmid uint64) (string, string, error) {
+	macAddress := ""
+
+	if ig.Settings.InstanceNetworkInterfaceMatch == NetworkInterfaceMatchMAC {
+		config := proxmox.VirtualMachineConfig{}
+		if err := ig.proxmox.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", node, vmid), &config); err != nil {
+			return "", "", fmt.Errorf("failed to get config of vm='%d': %w", vmid, err)
+		}
+
+		// First option of network device is the model with MAC address, e.g. virtio=BC:24:11:00:00:01
+		if device := parseNetworkDevice(config.Net0); len(device) > 0 {
+			macAddress = device[0].Value
+		}
+	}
+
+	requestedInterface, err := newNetworkInterfaceMatcher(ig.Settings.InstanceNetworkInterfaceMatch, ig.Settings.InstanceNetworkInterface, macAddress)
+	if err != nil {
+		return "", "", err
+	}
+
+	networkInterfaces, err := ig.agentGetNetworkInterfaces(ctx, node, vmid)
+	if err != nil {
+		return "", "", err
+	}
+
+	rules, err := parseAddressRules(ig.Settings.InternalAddressCIDRs, ig.Settings.ExternalAddressCIDRs)
+	if err != nil {
+		return "", "", err
+	}
+
+	return determineAddresses(networkInterfaces, requestedInterface, rules, ig.Settings.InstanceNetworkProtocol)
+}
+
+// Internal and external address of the instance.
+type instanceAddresses struct {
+	internal string
+	external string
+
+	recordedAt time.Time
+}
+
+// Returns recorded addresses of the instance, reading them with QEMU guest agent if they are not known yet or if they
+// were recorded long ago. Previously recorded addresses are returned if they can't be read again.
+func (ig *InstanceGroup) getInstanceAddresses(ctx context.Context, node string, vmid uint64) (instanceAddresses, error) {
+	ig.instanceAddressesMu.Lock()
+	recorded, ok := ig.instanceAddresses[vmid]
+	ig.instanceAddressesMu.Unlock()
+
+	if ok && time.Since(recorded.recordedAt) < instanceAddressTTL {
+		return recorded, nil
+	}
+
+	internalAddress, externalAddress, err := ig.determineInstanceAddresses(ctx, node, vmid)
+	if err != nil {
+		if ok {
+			ig.log.Warn("failed to refresh instance addresses, using recorded ones", "vmid", vmid, "internal", recorded.internal, "external", recorded.external, "err", err)
+			return recorded, nil
+		}
+
+		return instanceAddresses{}, err
+	}
+
+	addresses := instanceAddresses{internal: internalAddress, external: externalAddress}
+	ig.recordInstanceAddresses(vmid, addresses)
+
+	return addresses, nil
+}
+
+// Polls QEMU guest agent until the instance has address of requested protocol or until timeout is reached, then records it.
+func (ig *InstanceGroup) waitForInstanceAddresses(ctx context.Context, vm *proxmox.VirtualMachine) error {
+	// Address of reused VMID must not be returned for the new instance
+	ig.forgetInstanceAddresses(uint64(vm.VMID))
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(ig.Settings.InstanceAddressTimeout))
+	defer cancel()
+
+	for {
+		_, err := ig.getInstanceAddresses(timeoutCtx, vm.Node, uint64(vm.VMID))
+		if err == nil {
+			return nil
+		}
+
+		ig.log.Debug("instance has no address yet", "vmid", vm.VMID, "err", err)
+
+		select {
+		case <-timeoutCtx.Done():
+			if ctx.Err() != nil {
+				return fmt.Errorf("failed to wait for instance address: %w", ctx.Err())
+			}
+
+			return fmt.Errorf("timed out after %s, last error: %w", time.Duration(ig.Settings.InstanceAddressTimeout), err)
+		case <-time.After(instanceAddressPollInterval):
+		}
+	}
+}
+
+func (ig *InstanceGroup) recordInstanceAddresses(vmid uint64, addresses instanceAddresses) {
+	ig.instanceAddressesMu.Lock()
+	defer ig.instanceAddressesMu.Unlock()
+
+	if ig.instanceAddresses == nil {
+		ig.instanceAddresses = map[uint64]instanceAddresses{}
+	}
+
+	addresses.recordedAt = time.Now()
+	ig.instanceAddresses[vmid] = addresses
+}
+
+func (ig *InstanceGroup) forgetInstanceAddresses(vmid uint64) {
+	ig.instanceAddressesMu.Lock()
+	defer ig.instanceAddressesMu.Unlock()
+
+	delete(ig.instanceAddresses, vmid)
+}
@@ -1,8 +1,14 @@
 package plugin
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	hclog "github.com/hashicorp/go-hclog"
 	"github.com/luthermonson/go-proxmox"
 	"github.com/stretchr/testify/require"
 )
@@ -208,7 +214,7 @@ func Test_determineAddresses(t *testing.T) {
 
 	for _, testCase := range tests {
 		t.Run(testCase.name, func(t *testing.T) {
-			internalAddress, externalAddress, err := determineAddresses(testCase.networkInterfaces, testCase.requestedInterface, testCase.requestedProtocol)
+			internalAddress, externalAddress, err := determineAddresses(testCase.networkInterfaces, matchNetworkInterfaceName(testCase.requestedInterface), addressRules{}, testCase.requestedProtocol)
 
 			require.ErrorIs(t, err, testCase.expectedError)
 			require.Equal(t, testCase.expectedInternalAddress, internalAddress)
@@ -216,3 +222,337 @@ func Test_determineAddresses(t *testing.T) {
 		})
 	}
 }
+
+func Test_newNetworkInterfaceMatcher(t *testing.T) {
+	networkInterfaces := []*proxmox.AgentNetworkIface{
+		{
+			Name:            "lo",
+			HardwareAddress: "00:00:00:00:00:00",
+			IPAddresses:     []*proxmox.AgentNetworkIPAddress{{IPAddressType: "ipv4", IPAddress: "127.0.0.1"}},
+		},
+		{
+			Name:            "eth0",
+			HardwareAddress: "bc:24:11:00:00:01",
+			IPAddresses:     []*proxmox.AgentNetworkIPAddress{{IPAddressType: "ipv4", IPAddress: "192.168.0.1"}},
+		},
+		{
+			Name:            "enp6s18",
+			HardwareAddress: "bc:24:11:00:00:02",
+			IPAddresses:     []*proxmox.AgentNetworkIPAddress{{IPAddressType: "ipv4", IPAddress: "192.168.0.2"}},
+		},
+	}
+
+	tests := []struct {
+		name string
+
+		match      NetworkInterfaceMatch
+		pattern    string
+		macAddress string
+
+		expectedError   error
+		expectedAddress string
+	}{
+		{
+			name:            "Name",
+			match:           NetworkInterfaceMatchName,
+			pattern:         "enp6s18",
+			expectedAddress: "192.168.0.2",
+		},
+		{
+			name:          "Name not found",
+			match:         NetworkInterfaceMatchName,
+			pattern:       "ens18",
+			expectedError: ErrNoIPAddress,
+		},
+		{
+			name:            "Glob",
+			match:           NetworkInterfaceMatchGlob,
+			pattern:         "en*",
+			expectedAddress: "192.168.0.2",
+		},
+		{
+			name:            "Regex",
+			match:           NetworkInterfaceMatchRegex,
+			pattern:         "^(eth|en)",
+			expectedAddress: "192.168.0.1",
+		},
+		{
+			name:          "Invalid regex",
+			match:         NetworkInterfaceMatchRegex,
+			pattern:       "^(eth",
+			expectedError: ErrInvalidNetworkInterfaceMatch,
+		},
+		{
+			name:          "Invalid glob",
+			match:         NetworkInterfaceMatchGlob,
+			pattern:       "en[",
+			expectedError: ErrInvalidNetworkInterfaceMatch,
+		},
+		{
+			name:            "MAC",
+			match:           NetworkInterfaceMatchMAC,
+			macAddress:      "BC:24:11:00:00:02",
+			expectedAddress: "192.168.0.2",
+		},
+		{
+			name:          "MAC unknown",
+			match:         NetworkInterfaceMatchMAC,
+			expectedError: ErrNoIPAddress,
+		},
+		{
+			name:            "First non-loopback",
+			match:           NetworkInterfaceMatchFirst,
+			expectedAddress: "192.168.0.1",
+		},
+		{
+			name:          "Invalid match",
+			match:         "nic",
+			expectedError: ErrInvalidNetworkInterfaceMatch,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			internalAddress := ""
+
+			matcher, err := newNetworkInterfaceMatcher(testCase.match, testCase.pattern, testCase.macAddress)
+			if err == nil {
+				internalAddress, _, err = determineAddresses(networkInterfaces, matcher, addressRules{}, NetworkProtocolIPv4)
+			}
+
+			require.ErrorIs(t, err, testCase.expectedError)
+			require.Equal(t, testCase.expectedAddress, internalAddress)
+		})
+	}
+}
+
+func Test_determineAddresses_addressRules(t *testing.T) {
+	networkInterfaces := []*proxmox.AgentNetworkIface{
+		{
+			Name: "ens18",
+			IPAddresses: []*proxmox.AgentNetworkIPAddress{
+				{IPAddressType: "ipv4", IPAddress: "172.17.0.1"},
+				{IPAddressType: "ipv4", IPAddress: "10.50.1.2"},
+				{IPAddressType: "ipv4", IPAddress: "192.168.0.1"},
+				{IPAddressType: "ipv4", IPAddress: "8.8.8.8"},
+				{IPAddressType: "ipv6", IPAddress: "fd3b:47fc:de09::1"},
+				{IPAddressType: "ipv6", IPAddress: "2001:4860:4860::8888"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+
+		internalCIDRs     []string
+		externalCIDRs     []string
+		requestedProtocol NetworkProtocol
+
+		expectedError           error
+		expectedInternalAddress string
+		expectedExternalAddress string
+	}{
+		{
+			name:              "Default classification keeps the first address",
+			requestedProtocol: NetworkProtocolIPv4,
+
+			expectedInternalAddress: "172.17.0.1",
+			expectedExternalAddress: "8.8.8.8",
+		},
+		{
+			name:              "Internal deny list",
+			internalCIDRs:     []string{"!172.17.0.0/16"},
+			requestedProtocol: NetworkProtocolIPv4,
+
+			expectedInternalAddress: "10.50.1.2",
+			expectedExternalAddress: "8.8.8.8",
+		},
+		{
+			name:              "Private external network",
+			internalCIDRs:     []string{"192.168.0.0/16"},
+			externalCIDRs:     []string{"10.50.0.0/16"},
+			requestedProtocol: NetworkProtocolIPv4,
+
+			expectedInternalAddress: "192.168.0.1",
+			expectedExternalAddress: "10.50.1.2",
+		},
+		{
+			name:              "Earlier CIDR wins",
+			internalCIDRs:     []string{"192.168.0.0/16", "10.0.0.0/8", "172.16.0.0/12"},
+			requestedProtocol: NetworkProtocolIPv4,
+
+			expectedInternalAddress: "192.168.0.1",
+			expectedExternalAddress: "8.8.8.8",
+		},
+		{
+			name:              "Deny wins over allow",
+			internalCIDRs:     []string{"10.0.0.0/8", "!10.50.0.0/16"},
+			externalCIDRs:     []string{"10.0.0.0/8"},
+			requestedProtocol: NetworkProtocolIPv4,
+
+			expectedInternalAddress: "",
+			expectedExternalAddress: "10.50.1.2",
+		},
+		{
+			name:              "External falls back to internal",
+			externalCIDRs:     []string{"!0.0.0.0/0"},
+			requestedProtocol: NetworkProtocolIPv4,
+
+			expectedInternalAddress: "172.17.0.1",
+			expectedExternalAddress: "172.17.0.1",
+		},
+		{
+			name:              "IPv6",
+			internalCIDRs:     []string{"fd00::/8"},
+			externalCIDRs:     []string{"2001:4860::/32"},
+			requestedProtocol: NetworkProtocolIPv6,
+
+			expectedInternalAddress: "fd3b:47fc:de09::1",
+			expectedExternalAddress: "2001:4860:4860::8888",
+		},
+		{
+			name:              "No address allowed",
+			internalCIDRs:     []string{"100.64.0.0/10"},
+			externalCIDRs:     []string{"100.64.0.0/10"},
+			requestedProtocol: NetworkProtocolIPv4,
+
+			expectedError: ErrNoIPAddress,
+		},
+		{
+			name:              "Invalid CIDR",
+			internalCIDRs:     []string{"10.50.0.0"},
+			requestedProtocol: NetworkProtocolIPv4,
+
+			expectedError: ErrInvalidAddressCIDR,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			internalAddress, externalAddress := "", ""
+
+			rules, err := parseAddressRules(testCase.internalCIDRs, testCase.externalCIDRs)
+			if err == nil {
+				internalAddress, externalAddress, err = determineAddresses(networkInterfaces, matchNetworkInterfaceName("ens18"), rules, testCase.requestedProtocol)
+			}
+
+			require.ErrorIs(t, err, testCase.expectedError)
+			require.Equal(t, testCase.expectedInternalAddress, internalAddress)
+			require.Equal(t, testCase.expectedExternalAddress, externalAddress)
+		})
+	}
+}
+
+func TestInstanceGroup_waitForInstanceAddresses(t *testing.T) {
+	var requests atomic.Int32
+
+	// Address is assigned by DHCP only after the second request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 2 {
+			_, _ = w.Write([]byte(`{"data":{"result":[{"name":"ens18","ip-addresses":[]}]}}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"data":{"result":[{"name":"ens18","ip-addresses":[{"ip-address-type":"ipv4","ip-address":"192.168.0.10"}]}]}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: proxmox.NewClient(server.URL),
+		Settings: Settings{
+			InstanceNetworkInterface:      "ens18",
+			InstanceNetworkInterfaceMatch: NetworkInterfaceMatchName,
+			InstanceNetworkProtocol:       NetworkProtocolIPv4,
+			InstanceAddressTimeout:        Duration(10 * time.Second),
+		},
+	}
+
+	// Address of previous instance with the same VMID is not reused
+	ig.recordInstanceAddresses(100, instanceAddresses{internal: "192.168.0.99", external: "192.168.0.99"})
+
+	vm := &proxmox.VirtualMachine{Node: "pve1", VMID: 100}
+	require.NoError(t, ig.waitForInstanceAddresses(context.Background(), vm))
+	require.Equal(t, int32(2), requests.Load())
+
+	// Recorded address is returned without asking the agent
+	addresses, err := ig.getInstanceAddresses(context.Background(), vm.Node, 100)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.0.10", addresses.internal)
+	require.Equal(t, "192.168.0.10", addresses.external)
+	require.Equal(t, int32(2), requests.Load())
+
+	// Address is read again after the instance is removed
+	ig.forgetInstanceAddresses(100)
+
+	_, err = ig.getInstanceAddresses(context.Background(), vm.Node, 100)
+	require.NoError(t, err)
+	require.Equal(t, int32(3), requests.Load())
+}
+
+func TestInstanceGroup_getInstanceAddresses_refresh(t *testing.T) {
+	var available atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"data":{"result":[{"name":"ens18","ip-addresses":[{"ip-address-type":"ipv4","ip-address":"192.168.0.20"}]}]}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: proxmox.NewClient(server.URL),
+		Settings: Settings{
+			InstanceNetworkInterface:      "ens18",
+			InstanceNetworkInterfaceMatch: NetworkInterfaceMatchName,
+			InstanceNetworkProtocol:       NetworkProtocolIPv4,
+		},
+		instanceAddresses: map[uint64]instanceAddresses{
+			100: {internal: "192.168.0.10", external: "192.168.0.10", recordedAt: time.Now().Add(-instanceAddressTTL)},
+		},
+	}
+
+	// Expired addresses are kept when they can't be read again
+	addresses, err := ig.getInstanceAddresses(context.Background(), "pve1", 100)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.0.10", addresses.internal)
+
+	// Expired addresses are replaced with current ones
+	available.Store(true)
+
+	addresses, err = ig.getInstanceAddresses(context.Background(), "pve1", 100)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.0.20", addresses.internal)
+	require.Equal(t, "192.168.0.20", addresses.external)
+
+	// Refreshed addresses are recorded
+	available.Store(false)
+
+	addresses, err = ig.getInstanceAddresses(context.Background(), "pve1", 100)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.0.20", addresses.internal)
+}
+
+func TestInstanceGroup_waitForInstanceAddresses_timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"result":[]}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: proxmox.NewClient(server.URL),
+		Settings: Settings{
+			InstanceNetworkInterface: "ens18",
+			InstanceNetworkProtocol:  NetworkProtocolIPv4,
+			InstanceAddressTimeout:   Duration(100 * time.Millisecond),
+		},
+	}
+
+	err := ig.waitForInstanceAddresses(context.Background(), &proxmox.VirtualMachine{Node: "pve1", VMID: 100})
+	require.ErrorIs(t, err, ErrNoIPAddress)
+}
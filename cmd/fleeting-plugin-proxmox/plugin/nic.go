@@ -0,0 +1,206 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+var (
+	ErrNoNetworkDevice  = errors.New("instance has no network device")
+	ErrInvalidMACPrefix = errors.New("invalid MAC address prefix")
+)
+
+// Network device models supported by Proxmox VE.
+var networkModels = []string{
+	"e1000", "e1000-82540em", "e1000-82544gc", "e1000-82545em", "e1000e", "i82551", "i82557b",
+	"i82559er", "ne2k_isa", "ne2k_pci", "pcnet", "rtl8139", "virtio", "vmxnet3",
+}
+
+// Option of Proxmox VE network device, e.g. bridge=vmbr0.
+type networkDeviceOption struct {
+	Key   string
+	Value string
+}
+
+// Proxmox VE network device configuration (e.g. net0), options are kept in original order.
+type networkDevice []networkDeviceOption
+
+// Parses network device configuration like "virtio=BC:24:11:00:00:01,bridge=vmbr0,firewall=1".
+func parseNetworkDevice(config string) networkDevice {
+	device := networkDevice{}
+
+	for _, part := range strings.Split(config, ",") {
+		if part == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(part, "=")
+		device = append(device, networkDeviceOption{Key: key, Value: value})
+	}
+
+	return device
+}
+
+// Returns value of given option.
+func (d networkDevice) get(key string) (string, bool) {
+	for _, option := range d {
+		if option.Key == key {
+			return option.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Sets value of given option, appending it if not present.
+func (d *networkDevice) set(key, value string) {
+	for i, option := range *d {
+		if option.Key == key {
+			(*d)[i].Value = value
+			return
+		}
+	}
+
+	*d = append(*d, networkDeviceOption{Key: key, Value: value})
+}
+
+func (d networkDevice) String() string {
+	parts := make([]string, 0, len(d))
+
+	for _, option := range d {
+		parts = append(parts, option.Key+"="+option.Value)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Modifies first network device (net0) of the VM with given function.
+func (ig *InstanceGroup) updateInstanceNetworkDevice(ctx context.Context, vm *proxmox.VirtualMachine, update func(device *networkDevice) error) error {
+	if vm.VirtualMachineConfig == nil {
+		return fmt.Errorf("%w: vmid='%d'", ErrNoNetworkDevice, vm.VMID)
+	}
+
+	device := parseNetworkDevice(vm.VirtualMachineConfig.Net0)
+	if len(device) < 1 {
+		return fmt.Errorf("%w: vmid='%d'", ErrNoNetworkDevice, vm.VMID)
+	}
+
+	if err := update(&device); err != nil {
+		return err
+	}
+
+	if device.String() == vm.VirtualMachineConfig.Net0 {
+		return nil
+	}
+
+	config := map[string]string{"net0": device.String()}
+	if err := ig.proxmox.Put(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", vm.Node, vm.VMID), config, nil); err != nil {
+		return fmt.Errorf("failed to update network device of vm='%d': %w", vm.VMID, err)
+	}
+
+	vm.VirtualMachineConfig.Net0 = device.String()
+
+	return nil
+}
+
+// Applies configured bridge, VLAN tag, model, rate limit and MAC address to instance's network device.
+func (ig *InstanceGroup) configureInstanceNetwork(ctx context.Context, vm *proxmox.VirtualMachine) error {
+	settings := &ig.Settings
+
+	if settings.NetworkBridge == "" && settings.NetworkVLANTag == nil && settings.NetworkModel == "" && settings.NetworkRateLimit == nil && settings.NetworkMACPrefix == "" {
+		return nil
+	}
+
+	return ig.updateInstanceNetworkDevice(ctx, vm, func(device *networkDevice) error {
+		// First option is the model with MAC address, e.g. virtio=BC:24:11:00:00:01
+		model := &(*device)[0]
+
+		if settings.NetworkModel != "" {
+			model.Key = settings.NetworkModel
+		}
+
+		if settings.NetworkMACPrefix != "" {
+			prefix, err := parseMACPrefix(settings.NetworkMACPrefix)
+			if err != nil {
+				return err
+			}
+
+			// MAC address is kept if deployment was resumed
+			if !strings.HasPrefix(strings.ToUpper(model.Value), formatMAC(prefix)+":") {
+				model.Value, err = generateMAC(prefix)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if settings.NetworkBridge != "" {
+			device.set("bridge", settings.NetworkBridge)
+		}
+
+		if settings.NetworkVLANTag != nil {
+			device.set("tag", strconv.Itoa(*settings.NetworkVLANTag))
+		}
+
+		if settings.NetworkRateLimit != nil {
+			device.set("rate", strconv.FormatFloat(*settings.NetworkRateLimit, 'f', -1, 64))
+		}
+
+		return nil
+	})
+}
+
+// Parses MAC address prefix of 1 to 5 octets, e.g. BC:24:11.
+func parseMACPrefix(prefix string) ([]byte, error) {
+	parts := strings.Split(prefix, ":")
+	if len(parts) < 1 || len(parts) > 5 {
+		return nil, fmt.Errorf("%w: must have 1 to 5 octets", ErrInvalidMACPrefix)
+	}
+
+	octets := make([]byte, 0, len(parts))
+
+	for _, part := range parts {
+		octet, err := hex.DecodeString(part)
+		if err != nil || len(octet) != 1 {
+			return nil, fmt.Errorf("%w: octet='%s' must be two hex digits", ErrInvalidMACPrefix, part)
+		}
+
+		octets = append(octets, octet[0])
+	}
+
+	if octets[0]&0x01 != 0 {
+		return nil, fmt.Errorf("%w: must be unicast address, first octet must be even", ErrInvalidMACPrefix)
+	}
+
+	return octets, nil
+}
+
+// Generates random MAC address starting with given prefix.
+func generateMAC(prefix []byte) (string, error) {
+	mac := make([]byte, 6)
+	copy(mac, prefix)
+
+	if _, err := rand.Read(mac[len(prefix):]); err != nil {
+		return "", fmt.Errorf("failed to generate MAC address: %w", err)
+	}
+
+	return formatMAC(mac), nil
+}
+
+// Formats MAC address or its part as uppercase, colon separated octets.
+func formatMAC(octets []byte) string {
+	parts := make([]string, 0, len(octets))
+
+	for _, octet := range octets {
+		parts = append(parts, fmt.Sprintf("%02X", octet))
+	}
+
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseNetworkDevice(t *testing.T) {
+	device := parseNetworkDevice("virtio=BC:24:11:00:00:01,bridge=vmbr0,tag=10")
+
+	bridge, ok := device.get("bridge")
+	require.True(t, ok)
+	require.Equal(t, "vmbr0", bridge)
+
+	_, ok = device.get("firewall")
+	require.False(t, ok)
+
+	device.set("bridge", "vmbr1")
+	device.set("firewall", "1")
+
+	require.Equal(t, "virtio=BC:24:11:00:00:01,bridge=vmbr1,tag=10,firewall=1", device.String())
+}
+
+func TestInstanceGroup_configureInstanceNetwork(t *testing.T) {
+	client, requests := newRecordingServer(t, map[string]string{})
+
+	vlanTag := 20
+	rateLimit := 12.5
+
+	ig := InstanceGroup{
+		Settings: Settings{
+			NetworkBridge:    "vmbr1",
+			NetworkVLANTag:   &vlanTag,
+			NetworkModel:     "e1000",
+			NetworkRateLimit: &rateLimit,
+			NetworkMACPrefix: "02:AB",
+		},
+		proxmox: client,
+	}
+
+	vm := &proxmox.VirtualMachine{
+		Node:                 "pve1",
+		VMID:                 100,
+		VirtualMachineConfig: &proxmox.VirtualMachineConfig{Net0: "virtio=BC:24:11:00:00:01,bridge=vmbr0,firewall=1"},
+	}
+
+	require.NoError(t, ig.configureInstanceNetwork(context.Background(), vm))
+	require.Regexp(t, `^e1000=02:AB(:[0-9A-F]{2}){4},bridge=vmbr1,firewall=1,tag=20,rate=12.5$`, vm.VirtualMachineConfig.Net0)
+	require.Equal(t, []recordedRequest{
+		{Method: http.MethodPut, Path: "/nodes/pve1/qemu/100/config", Body: map[string]any{"net0": vm.VirtualMachineConfig.Net0}},
+	}, requests())
+
+	// Nothing changes when applied again, e.g. when deployment is resumed
+	require.NoError(t, ig.configureInstanceNetwork(context.Background(), vm))
+	require.Len(t, requests(), 1)
+
+	// Missing network device
+	vm.VirtualMachineConfig.Net0 = ""
+	require.ErrorIs(t, ig.configureInstanceNetwork(context.Background(), vm), ErrNoNetworkDevice)
+}
+
+func Test_parseMACPrefix(t *testing.T) {
+	tests := []struct {
+		prefix        string
+		expected      []byte
+		expectedError error
+	}{
+		{prefix: "BC:24:11", expected: []byte{0xbc, 0x24, 0x11}, expectedError: nil},
+		{prefix: "02", expected: []byte{0x02}, expectedError: nil},
+		{prefix: "bc:24:11:00:01", expected: []byte{0xbc, 0x24, 0x11, 0x00, 0x01}, expectedError: nil},
+		{prefix: "BC:24:11:00:01:02", expectedError: ErrInvalidMACPrefix},
+		{prefix: "BC:2", expectedError: ErrInvalidMACPrefix},
+		{prefix: "ZZ", expectedError: ErrInvalidMACPrefix},
+		{prefix: "01:00", expectedError: ErrInvalidMACPrefix},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			prefix, err := parseMACPrefix(tt.prefix)
+			require.ErrorIs(t, err, tt.expectedError)
+			require.Equal(t, tt.expected, prefix)
+		})
+	}
+}
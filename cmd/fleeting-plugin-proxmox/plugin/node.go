@@ -0,0 +1,229 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// Possible node states.
+const (
+	nodeStateOnline      = "online"
+	nodeStateMaintenance = "maintenance"
+)
+
+var (
+	ErrInsufficientNodeResources = errors.New("insufficient resources on node")
+	ErrNodeUnavailable           = errors.New("node is unavailable")
+)
+
+// Status of HA manager, only fields used by the plugin are decoded.
+type haManagerStatus struct {
+	ManagerStatus struct {
+		NodeStatus map[string]string `json:"node_status"`
+	} `json:"manager_status"`
+}
+
+// Returns state of every cluster node, nodes in HA maintenance mode are reported as in maintenance.
+func (ig *InstanceGroup) getNodeStates(ctx context.Context) (map[string]string, error) {
+	resources, err := ig.getClusterResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := map[string]string{}
+
+	for _, resource := range resources {
+		if resource.Type == "node" {
+			states[resource.Node] = resource.Status
+		}
+	}
+
+	// HA status might be not accessible or HA might be not configured at all, it is not critical
+	haStatus := haManagerStatus{}
+	if err := ig.proxmox.Get(ctx, "/cluster/ha/status/manager_status", &haStatus); err != nil {
+		ig.log.Debug("failed to get HA manager status, node maintenance mode will not be detected", "err", err)
+		return states, nil
+	}
+
+	for node, status := range haStatus.ManagerStatus.NodeStatus {
+		if status == nodeStateMaintenance {
+			states[node] = nodeStateMaintenance
+		}
+	}
+
+	return states, nil
+}
+
+// Fails if template's node is offline or in maintenance mode.
+func (ig *InstanceGroup) checkTemplateNodeAvailability(ctx context.Context) error {
+	template, err := ig.getPoolMember(ctx, *ig.Settings.TemplateID)
+	if err != nil {
+		return fmt.Errorf("failed to find template with id='%d': %w", *ig.Settings.TemplateID, err)
+	}
+
+	states, err := ig.getNodeStates(ctx)
+	if err != nil {
+		return err
+	}
+
+	node := template.Node
+
+	if state := states[node]; state != nodeStateOnline {
+		return fmt.Errorf("%w: template's node='%s' state='%s'", ErrNodeUnavailable, node, state)
+	}
+
+	return nil
+}
+
+// Drains running instances on nodes in maintenance mode. Draining instances are reported as deleting, so no new jobs
+// are scheduled on them, and are marked for removal once jobs already running on them had time to finish.
+func (ig *InstanceGroup) drainMaintenanceNodes(ctx context.Context, members []proxmox.ClusterResource, states map[string]string) {
+	if !ig.Settings.DrainMaintenanceNodes {
+		return
+	}
+
+	instancesToRemove := []*proxmox.ClusterResource{}
+
+	ig.drainingInstancesMu.Lock()
+
+	draining := make(map[uint64]time.Time, len(ig.drainingInstances))
+
+	for _, member := range members {
+		member := member
+
+		if !ig.isProxmoxResourceAnInstance(member) || member.Name != ig.Settings.InstanceNameRunning {
+			continue
+		}
+
+		// Instances keep draining after node leaves maintenance mode, as they are not used by fleeting anymore
+		startedAt, ok := ig.drainingInstances[member.VMID]
+		if !ok {
+			if states[member.Node] != nodeStateMaintenance {
+				continue
+			}
+
+			ig.log.Info("draining instance on node in maintenance mode", "vmid", member.VMID, "node", member.Node)
+			startedAt = time.Now()
+		}
+
+		if time.Since(startedAt) < time.Duration(ig.Settings.DrainMaintenanceNodesTimeout) {
+			draining[member.VMID] = startedAt
+			continue
+		}
+
+		ig.log.Info("instance drained, marking for removal", "vmid", member.VMID, "node", member.Node)
+		instancesToRemove = append(instancesToRemove, &member)
+	}
+
+	ig.drainingInstances = draining
+	ig.drainingInstancesMu.Unlock()
+
+	if len(instancesToRemove) < 1 {
+		return
+	}
+
+	if err := ig.markInstancesForRemoval(ctx, instancesToRemove...); err != nil {
+		ig.log.Error("failed to remove drained instances on nodes in maintenance mode", "err", err)
+	}
+}
+
+// Checks if instance is being drained and must not be used for new jobs.
+func (ig *InstanceGroup) isInstanceDraining(vmid uint64) bool {
+	ig.drainingInstancesMu.Lock()
+	defer ig.drainingInstancesMu.Unlock()
+
+	_, ok := ig.drainingInstances[vmid]
+
+	return ok
+}
+
+// Resources allocated to VMs on a node compared to its capacity.
+type nodeAllocation struct {
+	MaxMemory uint64
+	MaxCPU    uint64
+
+	AllocatedMemory uint64
+	AllocatedCPU    uint64
+}
+
+// Returns how many out of requested instances can be placed on template's node without exceeding configured resource ratios.
+func (ig *InstanceGroup) capInstancesByNodeResources(ctx context.Context, template *proxmox.VirtualMachine, count int) (int, error) {
+	if ig.Settings.NodeMaxMemoryRatio == nil && ig.Settings.NodeMaxCPURatio == nil {
+		return count, nil
+	}
+
+	resources, err := ig.getClusterResources(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	allocation := ig.getNodeAllocation(resources, template.Node)
+	fitting := count
+
+	if ig.Settings.NodeMaxMemoryRatio != nil {
+		fitting = min(fitting, instancesFittingNode(allocation.MaxMemory, allocation.AllocatedMemory, template.MaxMem, *ig.Settings.NodeMaxMemoryRatio))
+	}
+
+	if ig.Settings.NodeMaxCPURatio != nil {
+		fitting = min(fitting, instancesFittingNode(allocation.MaxCPU, allocation.AllocatedCPU, uint64(template.CPUs), *ig.Settings.NodeMaxCPURatio))
+	}
+
+	if fitting < 1 {
+		return 0, fmt.Errorf("%w: node='%s' max memory='%d' allocated memory='%d' max cpu='%d' allocated cpu='%d'",
+			ErrInsufficientNodeResources, template.Node, allocation.MaxMemory, allocation.AllocatedMemory, allocation.MaxCPU, allocation.AllocatedCPU)
+	}
+
+	if fitting < count {
+		ig.log.Warn("not enough resources on node for all requested instances", "node", template.Node, "requested", count, "allowed", fitting)
+	}
+
+	return fitting, nil
+}
+
+// Sums resources allocated on the node to running VMs and to this group's instances which are about to start.
+func (ig *InstanceGroup) getNodeAllocation(resources proxmox.ClusterResources, nodeName string) nodeAllocation {
+	allocation := nodeAllocation{}
+
+	for _, resource := range resources {
+		if resource.Node != nodeName {
+			continue
+		}
+
+		switch resource.Type {
+		case "node":
+			allocation.MaxMemory = resource.MaxMem
+			allocation.MaxCPU = resource.MaxCPU
+		case "qemu", "lxc":
+			pending := resource.Pool == ig.Settings.Pool && ig.isProxmoxResourceAnInstance(*resource) && resource.Name == ig.Settings.InstanceNameCreating
+
+			if resource.Status != "running" && !pending {
+				continue
+			}
+
+			allocation.AllocatedMemory += resource.MaxMem
+			allocation.AllocatedCPU += resource.MaxCPU
+		}
+	}
+
+	return allocation
+}
+
+// Calculates how many instances can be allocated while keeping allocation at most given ratio of capacity.
+func instancesFittingNode(capacity, allocated, perInstance uint64, maxRatio float64) int {
+	limit := uint64(math.Floor(float64(capacity) * maxRatio))
+
+	if allocated >= limit {
+		return 0
+	}
+
+	if perInstance == 0 {
+		return math.MaxInt
+	}
+
+	return int((limit - allocated) / perInstance)
+}
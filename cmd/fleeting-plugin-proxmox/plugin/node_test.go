@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_instancesFittingNode(t *testing.T) {
+	require.Equal(t, 4, instancesFittingNode(16, 0, 4, 1))
+	require.Equal(t, 2, instancesFittingNode(16, 8, 4, 1))
+	require.Equal(t, 6, instancesFittingNode(16, 8, 4, 2))
+	require.Equal(t, 0, instancesFittingNode(16, 16, 4, 1))
+	require.Equal(t, 0, instancesFittingNode(16, 10, 4, 0.5))
+	require.Equal(t, math.MaxInt, instancesFittingNode(16, 0, 0, 1))
+}
+
+func TestInstanceGroup_getNodeAllocation(t *testing.T) {
+	ig := InstanceGroup{
+		Settings: Settings{
+			Pool:       samplePool,
+			TemplateID: &sampleTemplateID,
+		},
+	}
+	ig.Settings.FillWithDefaults()
+
+	resources := proxmox.ClusterResources{
+		{Type: "node", Node: "pve1", MaxMem: 64, MaxCPU: 16},
+		{Type: "node", Node: "pve2", MaxMem: 128, MaxCPU: 32},
+		{Type: "storage", Node: "pve1", MaxDisk: 1000},
+		{Type: "qemu", Node: "pve1", VMID: 100, Status: "running", MaxMem: 8, MaxCPU: 2},
+		{Type: "lxc", Node: "pve1", VMID: 101, Status: "running", MaxMem: 2, MaxCPU: 1},
+		{Type: "qemu", Node: "pve1", VMID: 102, Status: "stopped", MaxMem: 32, MaxCPU: 8},
+		{Type: "qemu", Node: "pve1", VMID: 103, Status: "stopped", MaxMem: 4, MaxCPU: 2, Pool: samplePool, Name: "fleeting-creating"},
+		{Type: "qemu", Node: "pve1", VMID: uint64(sampleTemplateID), Status: "stopped", MaxMem: 4, MaxCPU: 2, Pool: samplePool, Name: "fleeting-creating"},
+		{Type: "qemu", Node: "pve2", VMID: 104, Status: "running", MaxMem: 8, MaxCPU: 2},
+	}
+
+	allocation := ig.getNodeAllocation(resources, "pve1")
+	require.Equal(t, nodeAllocation{
+		MaxMemory:       64,
+		MaxCPU:          16,
+		AllocatedMemory: 14,
+		AllocatedCPU:    5,
+	}, allocation)
+}
+
+const sampleNodeResources = `[
+	{"type":"node","node":"pve1","status":"online"},
+	{"type":"node","node":"pve2","status":"online"},
+	{"type":"node","node":"pve3","status":"offline"},
+	{"type":"qemu","node":"pve1","vmid":20,"name":"template","pool":"sample_pool"}
+]`
+
+func TestInstanceGroup_getNodeStates(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses map[string]string
+		expected  map[string]string
+	}{
+		{
+			name: "HA maintenance mode",
+			responses: map[string]string{
+				"/cluster/resources":                sampleNodeResources,
+				"/cluster/ha/status/manager_status": `{"manager_status":{"node_status":{"pve1":"online","pve2":"maintenance"}}}`,
+			},
+			expected: map[string]string{"pve1": nodeStateOnline, "pve2": nodeStateMaintenance, "pve3": "offline"},
+		},
+		{
+			name: "HA status not accessible",
+			responses: map[string]string{
+				"/cluster/resources": sampleNodeResources,
+			},
+			expected: map[string]string{"pve1": nodeStateOnline, "pve2": nodeStateOnline, "pve3": "offline"},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			client, _ := newRecordingServer(t, testCase.responses)
+			ig := InstanceGroup{log: hclog.NewNullLogger(), proxmox: client}
+
+			states, err := ig.getNodeStates(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, testCase.expected, states)
+		})
+	}
+}
+
+func TestInstanceGroup_checkTemplateNodeAvailability(t *testing.T) {
+	tests := []struct {
+		name          string
+		templateNode  string
+		expectedError error
+	}{
+		{name: "Online", templateNode: "pve1"},
+		{name: "Maintenance", templateNode: "pve2", expectedError: ErrNodeUnavailable},
+		{name: "Offline", templateNode: "pve3", expectedError: ErrNodeUnavailable},
+		{name: "Template not found", expectedError: ErrNotFound},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			resources := sampleNodeResources
+			if testCase.templateNode != "" {
+				resources = strings.Replace(resources, `"node":"pve1","vmid":20`, fmt.Sprintf(`"node":"%s","vmid":20`, testCase.templateNode), 1)
+			} else {
+				resources = strings.Replace(resources, `"vmid":20`, `"vmid":21`, 1)
+			}
+
+			client, _ := newRecordingServer(t, map[string]string{
+				"/cluster/resources":                resources,
+				"/cluster/ha/status/manager_status": `{"manager_status":{"node_status":{"pve2":"maintenance"}}}`,
+			})
+
+			ig := InstanceGroup{
+				log:      hclog.NewNullLogger(),
+				proxmox:  client,
+				Settings: Settings{Pool: samplePool, TemplateID: &sampleTemplateID},
+			}
+
+			err := ig.checkTemplateNodeAvailability(context.Background())
+			if testCase.expectedError == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, testCase.expectedError)
+			}
+		})
+	}
+}
+
+func TestInstanceGroup_drainMaintenanceNodes(t *testing.T) {
+	client, requests := newRecordingServer(t, map[string]string{
+		"/nodes/pve2/status":                  `{}`,
+		"/nodes/pve2/qemu/101/status/current": `{"vmid":101,"status":"running"}`,
+		"/nodes/pve2/qemu/101/config":         `{}`,
+	})
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+		Settings: Settings{
+			Pool:                         samplePool,
+			TemplateID:                   &sampleTemplateID,
+			DrainMaintenanceNodes:        true,
+			DrainMaintenanceNodesTimeout: Duration(time.Hour),
+		},
+		instanceCollectionTrigger: make(chan struct{}, triggerChannelCapacity),
+	}
+	ig.Settings.FillWithDefaults()
+
+	members := []proxmox.ClusterResource{
+		{Type: "qemu", Node: "pve1", VMID: 100, Name: "fleeting-running", Pool: samplePool},
+		{Type: "qemu", Node: "pve2", VMID: 101, Name: "fleeting-running", Pool: samplePool},
+		{Type: "qemu", Node: "pve2", VMID: 102, Name: "fleeting-creating", Pool: samplePool},
+		{Type: "qemu", Node: "pve2", VMID: uint64(sampleTemplateID), Name: "fleeting-running", Pool: samplePool},
+	}
+	states := map[string]string{"pve1": nodeStateOnline, "pve2": nodeStateMaintenance}
+
+	// Running instance on node in maintenance mode is reported as deleting, but not removed while its jobs might run
+	ig.drainMaintenanceNodes(context.Background(), members, states)
+	require.False(t, ig.isInstanceDraining(100))
+	require.True(t, ig.isInstanceDraining(101))
+	require.False(t, ig.isInstanceDraining(102))
+	require.Empty(t, requests())
+
+	// Instance keeps draining after node leaves maintenance mode
+	states["pve2"] = nodeStateOnline
+	ig.drainMaintenanceNodes(context.Background(), members, states)
+	require.True(t, ig.isInstanceDraining(101))
+	require.Empty(t, requests())
+
+	// Instance is removed once drained
+	ig.drainingInstances[101] = time.Now().Add(-time.Hour)
+	ig.drainMaintenanceNodes(context.Background(), members, states)
+	require.False(t, ig.isInstanceDraining(101))
+	require.Equal(t, []recordedRequest{
+		{Method: "POST", Path: "/nodes/pve2/qemu/101/config", Body: map[string]any{"name": "fleeting-removing"}},
+	}, requests())
+
+	// Instances are not drained when disabled
+	ig.Settings.DrainMaintenanceNodes = false
+	states["pve2"] = nodeStateMaintenance
+	ig.drainMaintenanceNodes(context.Background(), members, states)
+	require.False(t, ig.isInstanceDraining(101))
+}
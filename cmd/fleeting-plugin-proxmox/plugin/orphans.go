@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/luthermonson/go-proxmox"
+)
+
+// Handles instances in the pool which name does not match any instance state according to configured orphan policy.
+// Returns number of orphaned instances by action taken, which is logged as well.
+func (ig *InstanceGroup) handleOrphanedInstances(ctx context.Context, members []proxmox.ClusterResource) map[string]int {
+	actions := map[string]int{}
+
+	if ig.Settings.OrphanPolicy == OrphanPolicyIgnore {
+		return actions
+	}
+
+	templateTags := ig.getTemplateTags(ctx)
+
+	for _, member := range members {
+		member := member
+
+		if !ig.isOrphanedInstance(member, templateTags) {
+			continue
+		}
+
+		log := ig.log.With("name", member.Name, "vmid", member.VMID, "node", member.Node, "status", member.Status, "policy", ig.Settings.OrphanPolicy)
+
+		actions[ig.handleOrphanedInstance(ctx, &member, log)]++
+	}
+
+	if len(actions) > 0 {
+		ig.log.Info("collector handled orphaned instances", "policy", ig.Settings.OrphanPolicy, "actions", actions)
+	}
+
+	return actions
+}
+
+// Applies orphan policy to the instance and returns action taken.
+func (ig *InstanceGroup) handleOrphanedInstance(ctx context.Context, member *proxmox.ClusterResource, log hclog.Logger) string {
+	switch ig.Settings.OrphanPolicy {
+	case OrphanPolicyAdopt:
+		if member.Status != "running" {
+			log.Warn("found orphaned instance, cannot adopt it as it is not running")
+			return "not_adopted"
+		}
+
+		log.Info("found orphaned instance, adopting it")
+
+		if err := ig.adoptInstance(ctx, member); err != nil {
+			log.Error("failed to adopt orphaned instance", "err", err)
+			return "adopt_failed"
+		}
+
+		return "adopted"
+	case OrphanPolicyRemove:
+		log.Info("found orphaned instance, marking for removal")
+
+		err := ig.claimOrphanedInstance(ctx, member)
+		if err == nil {
+			err = ig.markInstancesForRemoval(ctx, member)
+		}
+
+		if err != nil {
+			log.Error("failed to mark orphaned instance for removal", "err", err)
+			return "remove_failed"
+		}
+
+		return "removed"
+	default:
+		log.Warn("found orphaned instance")
+		return "reported"
+	}
+}
+
+// Checks if the pool member is an instance of this group with unknown name, or an untagged instance left by a plugin
+// without instance group ID. Untagged instances being created are skipped as they might be tagged by another group yet.
+func (ig *InstanceGroup) isOrphanedInstance(member proxmox.ClusterResource, templateTags []string) bool {
+	if ig.isProxmoxResourceAnInstance(member) {
+		return !ig.isProxmoxResourceNameKnown(member)
+	}
+
+	return ig.isUntaggedInstance(member, templateTags) && member.Name != ig.Settings.InstanceNameCreating
+}
+
+// Checks if the VM has no tags except those cloned from the template, while instance group ID is configured.
+func (ig *InstanceGroup) isUntaggedInstance(member proxmox.ClusterResource, templateTags []string) bool {
+	if ig.Settings.InstanceGroupID == "" || member.Type != "qemu" || member.VMID == uint64(*ig.Settings.TemplateID) {
+		return false
+	}
+
+	for _, tag := range splitProxmoxTags(member.Tags) {
+		if !slices.Contains(templateTags, tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns tags of the template, which are copied to every clone.
+func (ig *InstanceGroup) getTemplateTags(ctx context.Context) []string {
+	resources, err := ig.getClusterResources(ctx)
+	if err != nil {
+		ig.log.Warn("failed to get template tags", "err", err)
+		return nil
+	}
+
+	for _, resource := range resources {
+		if resource.Type == "qemu" && resource.VMID == uint64(*ig.Settings.TemplateID) {
+			return splitProxmoxTags(resource.Tags)
+		}
+	}
+
+	return nil
+}
+
+// Tags orphaned instance with instance group ID, so it is handled as any other instance of this group.
+func (ig *InstanceGroup) claimOrphanedInstance(ctx context.Context, member *proxmox.ClusterResource) error {
+	if ig.isProxmoxResourceOwned(*member) {
+		return nil
+	}
+
+	vm, err := ig.getProxmoxVMOnNode(ctx, int(member.VMID), member.Node)
+	if err != nil {
+		return err
+	}
+
+	return ig.stampInstanceOwnership(ctx, vm)
+}
+
+// Tags instance and renames it to running instance name so it is reported as running, if it passes readiness checks.
+func (ig *InstanceGroup) adoptInstance(ctx context.Context, member *proxmox.ClusterResource) error {
+	vm, err := ig.getProxmoxVMOnNode(ctx, int(member.VMID), member.Node)
+	if err != nil {
+		return err
+	}
+
+	// Readiness is checked once, instance is checked again on next collection if it is not ready yet
+	if ig.Settings.ReadinessCheck.IsEnabled() {
+		if err := ig.checkInstanceReadiness(ctx, vm); err != nil {
+			return fmt.Errorf("instance did not pass readiness checks: %w", err)
+		}
+	}
+
+	if err := ig.stampInstanceOwnership(ctx, vm); err != nil {
+		return err
+	}
+
+	// Orphan is not in use, so generated password can be set
+	if err := ig.configureInstanceConnector(ctx, vm); err != nil {
+		return err
+	}
+
+	task, err := vm.Config(ctx, proxmox.VirtualMachineOption{
+		Name:  "name",
+		Value: ig.Settings.InstanceNameRunning,
+	})
+	defer ig.invalidateInventory()
+
+	if err == nil {
+		err = waitForTask(ctx, task, proxmoxTaskWaitTimeout)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to rename instance: %w", err)
+	}
+
+	return nil
+}
+
+func (ig *InstanceGroup) isProxmoxResourceNameKnown(member proxmox.ClusterResource) bool {
+	return member.Name == ig.Settings.InstanceNameCreating ||
+		member.Name == ig.Settings.InstanceNameRunning ||
+		member.Name == ig.Settings.InstanceNameRemoving
+}
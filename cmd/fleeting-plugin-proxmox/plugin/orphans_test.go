@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceGroup_isProxmoxResourceNameKnown(t *testing.T) {
+	ig := InstanceGroup{}
+	ig.Settings.FillWithDefaults()
+
+	require.True(t, ig.isProxmoxResourceNameKnown(proxmox.ClusterResource{Name: "fleeting-creating"}))
+	require.True(t, ig.isProxmoxResourceNameKnown(proxmox.ClusterResource{Name: "fleeting-running"}))
+	require.True(t, ig.isProxmoxResourceNameKnown(proxmox.ClusterResource{Name: "fleeting-removing"}))
+	require.False(t, ig.isProxmoxResourceNameKnown(proxmox.ClusterResource{Name: "renamed-by-hand"}))
+	require.False(t, ig.isProxmoxResourceNameKnown(proxmox.ClusterResource{Name: ""}))
+}
+
+func TestInstanceGroup_isOrphanedInstance(t *testing.T) {
+	templateTags := []string{"linux"}
+
+	tests := []struct {
+		name string
+
+		instanceGroupID string
+		member          proxmox.ClusterResource
+
+		expected bool
+	}{
+		{
+			name:     "Known name",
+			member:   proxmox.ClusterResource{Type: "qemu", VMID: 100, Name: "fleeting-running"},
+			expected: false,
+		},
+		{
+			name:     "Unknown name",
+			member:   proxmox.ClusterResource{Type: "qemu", VMID: 100, Name: "renamed-by-hand"},
+			expected: true,
+		},
+		{
+			name:     "Template",
+			member:   proxmox.ClusterResource{Type: "qemu", VMID: uint64(sampleTemplateID), Name: "template"},
+			expected: false,
+		},
+		{
+			name:            "Tagged with instance group ID",
+			instanceGroupID: "runners",
+			member:          proxmox.ClusterResource{Type: "qemu", VMID: 100, Name: "renamed-by-hand", Tags: "linux;runners"},
+			expected:        true,
+		},
+		{
+			name:            "Tagged with another instance group ID",
+			instanceGroupID: "runners",
+			member:          proxmox.ClusterResource{Type: "qemu", VMID: 100, Name: "renamed-by-hand", Tags: "linux;others"},
+			expected:        false,
+		},
+		{
+			name:            "Untagged legacy instance with known name",
+			instanceGroupID: "runners",
+			member:          proxmox.ClusterResource{Type: "qemu", VMID: 100, Name: "fleeting-running", Tags: "linux"},
+			expected:        true,
+		},
+		{
+			name:            "Untagged instance being created",
+			instanceGroupID: "runners",
+			member:          proxmox.ClusterResource{Type: "qemu", VMID: 100, Name: "fleeting-creating"},
+			expected:        false,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			ig := InstanceGroup{
+				Settings: Settings{
+					TemplateID:      &sampleTemplateID,
+					InstanceGroupID: testCase.instanceGroupID,
+				},
+			}
+			ig.Settings.FillWithDefaults()
+
+			require.Equal(t, testCase.expected, ig.isOrphanedInstance(testCase.member, templateTags))
+		})
+	}
+}
+
+func TestInstanceGroup_handleOrphanedInstances(t *testing.T) {
+	client, _ := newRecordingServer(t, map[string]string{
+		"/cluster/resources": fmt.Sprintf(`[{"type":"qemu","node":"pve1","vmid":%d,"tags":"linux"}]`, sampleTemplateID),
+	})
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: client,
+		Settings: Settings{
+			TemplateID:      &sampleTemplateID,
+			InstanceGroupID: "runners",
+			OrphanPolicy:    OrphanPolicyReport,
+		},
+	}
+	ig.Settings.FillWithDefaults()
+
+	actions := ig.handleOrphanedInstances(context.Background(), []proxmox.ClusterResource{
+		{Type: "qemu", Node: "pve1", VMID: 100, Name: "fleeting-running", Tags: "linux;runners"},
+		{Type: "qemu", Node: "pve1", VMID: 101, Name: "renamed-by-hand", Tags: "linux;runners"},
+		{Type: "qemu", Node: "pve1", VMID: 102, Name: "fleeting-running", Tags: "linux"},
+	})
+
+	require.Equal(t, map[string]int{"reported": 2}, actions)
+}
+
+func TestInstanceGroup_adoptInstance_notReady(t *testing.T) {
+	ig := InstanceGroup{
+		log: hclog.NewNullLogger(),
+		Settings: Settings{
+			ReadinessCheck: ReadinessCheckSettings{TCPPort: 1},
+		},
+		instanceAddresses: map[uint64]instanceAddresses{
+			100: {internal: "127.0.0.1", external: "127.0.0.1"},
+		},
+	}
+
+	client, requests := newRecordingServer(t, map[string]string{
+		"/nodes/pve1/status":                  `{}`,
+		"/nodes/pve1/qemu/100/status/current": `{"vmid":100,"status":"running"}`,
+		"/nodes/pve1/qemu/100/config":         `{}`,
+	})
+	ig.proxmox = client
+
+	err := ig.adoptInstance(context.Background(), &proxmox.ClusterResource{Type: "qemu", Node: "pve1", VMID: 100})
+	require.ErrorIs(t, err, ErrInstanceNotReady)
+
+	// Instance is neither tagged nor renamed
+	require.Empty(t, requests())
+}
@@ -2,7 +2,6 @@ package plugin
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,22 +25,12 @@ func (ig *InstanceGroup) getProxmoxPool(ctx context.Context) (*proxmox.Pool, err
 
 // Where possible, use getProxmoxVMOnNode instead as it makes less calls to API.
 func (ig *InstanceGroup) getProxmoxVM(ctx context.Context, vmid int) (*proxmox.VirtualMachine, error) {
-	pool, err := ig.getProxmoxPool(ctx)
+	member, err := ig.getPoolMember(ctx, vmid)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, member := range pool.Members {
-		if member.Type != "qemu" {
-			continue
-		}
-
-		if member.VMID == uint64(vmid) {
-			return ig.getProxmoxVMOnNode(ctx, vmid, member.Node)
-		}
-	}
-
-	return nil, ErrNotFound
+	return ig.getProxmoxVMOnNode(ctx, vmid, member.Node)
 }
 
 func (ig *InstanceGroup) getProxmoxVMOnNode(ctx context.Context, vmid int, nodeName string) (*proxmox.VirtualMachine, error) {
@@ -58,43 +47,63 @@ func (ig *InstanceGroup) getProxmoxVMOnNode(ctx context.Context, vmid int, nodeN
 	return vm, nil
 }
 
-func (ig *InstanceGroup) getProxmoxClient() (*proxmox.Client, error) {
-	url, err := url.Parse(ig.Settings.URL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse URL='%s': %w", ig.Settings.URL, err)
+func (ig *InstanceGroup) getProxmoxClient() (*proxmox.Client, *authTransport, error) {
+	urls := make([]*url.URL, 0, len(ig.Settings.GetURLs()))
+
+	for _, rawURL := range ig.Settings.GetURLs() {
+		url, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse URL='%s': %w", rawURL, err)
+		}
+
+		urls = append(urls, url.JoinPath("/api2/json"))
 	}
 
-	credentials, err := ig.getProxmoxCredentials()
+	// Fail early if credentials can't be loaded, they are loaded again on first request
+	if _, err := ig.getProxmoxCredentials(); err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig, err := ig.getTLSConfig()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+
+	failover := newFailoverTransport(transport, ig.log, urls, ig.handleEndpointSwitchover)
+	auth := newAuthTransport(failover, urls[0], ig.getProxmoxCredentials)
+
 	httpClient := http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				//nolint:gosec
-				InsecureSkipVerify: ig.Settings.InsecureSkipTLSVerify,
-			},
-		},
+		Transport: auth,
 	}
 
-	return proxmox.NewClient(
-		url.JoinPath("/api2/json").String(),
-		proxmox.WithCredentials(credentials),
-		proxmox.WithHTTPClient(&httpClient),
-	), nil
+	return proxmox.NewClient(urls[0].String(), proxmox.WithHTTPClient(&httpClient)), auth, nil
 }
 
-func (ig *InstanceGroup) getProxmoxCredentials() (*proxmox.Credentials, error) {
-	credentialsFile, err := os.Open(ig.Settings.CredentialsFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open credentials file from path='%s': %w", ig.Settings.CredentialsFilePath, err)
+func (ig *InstanceGroup) getProxmoxCredentials() (*Credentials, error) {
+	credentials := Credentials{}
+
+	if ig.Settings.CredentialsFilePath != "" {
+		credentialsFile, err := os.Open(ig.Settings.CredentialsFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open credentials file from path='%s': %w", ig.Settings.CredentialsFilePath, err)
+		}
+		defer credentialsFile.Close()
+
+		if err := json.NewDecoder(credentialsFile).Decode(&credentials); err != nil {
+			return nil, fmt.Errorf("failed to decode credentials file from path='%s': %w", ig.Settings.CredentialsFilePath, err)
+		}
+	}
+
+	if err := credentials.resolve(ig.Settings.CredentialsFromEnv); err != nil {
+		return nil, err
 	}
-	defer credentialsFile.Close()
 
-	credentials := proxmox.Credentials{}
-	if err := json.NewDecoder(credentialsFile).Decode(&credentials); err != nil {
-		return nil, fmt.Errorf("failed to decode credentials file from path='%s': %w", ig.Settings.CredentialsFilePath, err)
+	if err := credentials.validate(); err != nil {
+		return nil, err
 	}
 
 	return &credentials, nil
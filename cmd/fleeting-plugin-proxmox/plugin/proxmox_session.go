@@ -2,12 +2,16 @@ package plugin
 
 import (
 	"context"
+	"net/url"
 	"time"
 )
 
 const (
 	sessionTicketRefreshInterval = 1 * time.Hour
 	sessionTicketRefreshTimeout  = 5 * time.Second
+
+	// How often credentials are loaded again to pick up rotated ones.
+	credentialsWatchInterval = 10 * time.Second
 )
 
 func (ig *InstanceGroup) startSessionTicketRefresher() {
@@ -20,28 +24,66 @@ func (ig *InstanceGroup) startSessionTicketRefresher() {
 }
 
 func (ig *InstanceGroup) runSessionTicketRefresher() {
+	refreshTicker := time.NewTicker(sessionTicketRefreshInterval)
+	defer refreshTicker.Stop()
+
+	watchTicker := time.NewTicker(credentialsWatchInterval)
+	defer watchTicker.Stop()
+
 	for {
 		select {
 		case <-ig.sessionTicketRefresherShutdownTrigger:
 			return
-		case <-time.After(sessionTicketRefreshInterval):
-			func() {
-				ctx, cancel := context.WithTimeout(context.Background(), sessionTicketRefreshTimeout)
-				defer cancel()
-
-				credentials, err := ig.getProxmoxCredentials()
-				if err != nil {
-					ig.log.Error("failed to refresh proxmox session, could not read credentials", "err", err)
-					return
-				}
-
-				_, err = ig.proxmox.Ticket(ctx, credentials)
-				if err != nil {
-					ig.log.Error("failed to refresh proxmox session", "err", err)
-				}
-
-				ig.log.Info("refreshed proxmox session")
-			}()
+		case <-refreshTicker.C:
+			ig.refreshSessionTicket()
+		case <-ig.sessionTicketRefreshTrigger:
+			ig.refreshSessionTicket()
+		case <-watchTicker.C:
+			ig.reloadCredentials()
 		}
 	}
 }
+
+// Acquires new session ticket using current credentials.
+func (ig *InstanceGroup) refreshSessionTicket() {
+	ctx, cancel := context.WithTimeout(context.Background(), sessionTicketRefreshTimeout)
+	defer cancel()
+
+	if err := ig.auth.refresh(ctx); err != nil {
+		ig.log.Error("failed to refresh proxmox session", "err", err)
+		return
+	}
+
+	ig.log.Info("refreshed proxmox session")
+}
+
+// Loads credentials again and, if they were changed, starts using them.
+func (ig *InstanceGroup) reloadCredentials() {
+	ctx, cancel := context.WithTimeout(context.Background(), sessionTicketRefreshTimeout)
+	defer cancel()
+
+	credentials, err := ig.getProxmoxCredentials()
+	if err != nil {
+		ig.log.Warn("failed to reload proxmox credentials", "err", err)
+		return
+	}
+
+	changed, err := ig.auth.update(ctx, credentials)
+	if err != nil {
+		ig.log.Error("failed to authenticate with reloaded proxmox credentials, keeping previous ones", "err", err)
+		return
+	}
+
+	if changed {
+		ig.log.Info("reloaded proxmox credentials")
+	}
+}
+
+// Signals session ticket refresher to re-acquire session ticket from the endpoint which API calls were switched over to.
+func (ig *InstanceGroup) handleEndpointSwitchover(_ *url.URL) {
+	// Called while serving a request, refresh already pending is enough
+	select {
+	case ig.sessionTicketRefreshTrigger <- struct{}{}:
+	default:
+	}
+}
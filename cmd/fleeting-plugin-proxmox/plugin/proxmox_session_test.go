@@ -0,0 +1,18 @@
+package plugin
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceGroup_handleEndpointSwitchover(t *testing.T) {
+	ig := InstanceGroup{sessionTicketRefreshTrigger: make(chan struct{}, 1)}
+
+	// Switchovers don't block requests and pending refresh is shared
+	ig.handleEndpointSwitchover(&url.URL{Host: "pve1:8006"})
+	ig.handleEndpointSwitchover(&url.URL{Host: "pve2:8006"})
+
+	require.Len(t, ig.sessionTicketRefreshTrigger, 1)
+}
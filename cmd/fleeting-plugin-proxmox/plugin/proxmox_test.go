@@ -25,7 +25,7 @@ func TestInstanceGroup_getProxmoxClient(t *testing.T) {
 	)
 	require.NoError(t, err)
 
-	_, err = ig.getProxmoxClient()
+	_, _, err = ig.getProxmoxClient()
 	require.NoError(t, err)
 }
 
@@ -66,3 +66,27 @@ func TestInstanceGroup_getProxmoxCredentials(t *testing.T) {
 	require.Equal(t, "oQcW8N246FODI6Qui", credentials.Username)
 	require.Equal(t, `88u3[kKLJ{gU7A£fhWq`, credentials.Password)
 }
+
+func TestInstanceGroup_getProxmoxCredentials_fromEnv(t *testing.T) {
+	ig := InstanceGroup{
+		Settings: Settings{
+			CredentialsFromEnv: true,
+		},
+	}
+
+	// Nothing set
+	_, err := ig.getProxmoxCredentials()
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	t.Setenv("PROXMOX_TOKEN_ID", "fleeting@pve!plugin")
+	t.Setenv("PROXMOX_TOKEN_SECRET", "c0ffee")
+
+	credentials, err := ig.getProxmoxCredentials()
+	require.NoError(t, err)
+	require.True(t, credentials.IsAPIToken())
+	require.Equal(t, "fleeting@pve!plugin", credentials.TokenID)
+	require.Equal(t, "c0ffee", credentials.TokenSecret)
+
+	_, _, err = ig.getProxmoxClient()
+	require.NoError(t, err)
+}
@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+const readinessCheckDialTimeout = 5 * time.Second
+
+var ErrInstanceNotReady = errors.New("instance is not ready")
+
+// Waits until all configured readiness checks pass for the instance or until readiness timeout is reached.
+func (ig *InstanceGroup) waitForInstanceReadiness(ctx context.Context, vm *proxmox.VirtualMachine) error {
+	settings := &ig.Settings.ReadinessCheck
+
+	if !settings.IsEnabled() {
+		return nil
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(settings.Timeout))
+	defer cancel()
+
+	for {
+		err := ig.checkInstanceReadiness(timeoutCtx, vm)
+		if err == nil {
+			return nil
+		}
+
+		ig.log.Debug("instance is not ready yet", "vmid", vm.VMID, "err", err)
+
+		select {
+		case <-timeoutCtx.Done():
+			if ctx.Err() != nil {
+				return fmt.Errorf("failed to wait for instance readiness: %w", ctx.Err())
+			}
+
+			return fmt.Errorf("%w: timed out after %s, last error: %w", ErrInstanceNotReady, time.Duration(settings.Timeout), err)
+		case <-time.After(time.Duration(settings.Interval)):
+		}
+	}
+}
+
+// Runs all configured readiness checks once.
+func (ig *InstanceGroup) checkInstanceReadiness(ctx context.Context, vm *proxmox.VirtualMachine) error {
+	settings := &ig.Settings.ReadinessCheck
+
+	if len(settings.Exec) > 0 {
+		status, err := ig.agentExec(ctx, vm, settings.Exec, time.Duration(settings.Timeout))
+		if err != nil {
+			return err
+		}
+
+		if !status.succeeded() {
+			return fmt.Errorf("%w: readiness command exited with code='%d' signal='%d' stderr='%s'", ErrInstanceNotReady, status.ExitCode, status.Signal, status.ErrData)
+		}
+	}
+
+	if settings.File != "" {
+		if _, err := ig.agentFileRead(ctx, vm, settings.File); err != nil {
+			return err
+		}
+	}
+
+	if settings.TCPPort != 0 {
+		addresses, err := ig.getInstanceAddresses(ctx, vm.Node, uint64(vm.VMID))
+		if err != nil {
+			return err
+		}
+
+		// Instances with public address only have no internal address
+		address := addresses.internal
+		if address == "" {
+			address = addresses.external
+		}
+
+		if err := checkTCPPort(ctx, address, settings.TCPPort); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checks if given TCP port accepts connections on given address.
+func checkTCPPort(ctx context.Context, address string, port int) error {
+	// Empty host would be dialed on the local machine
+	if address == "" {
+		return fmt.Errorf("%w: tcp port='%d' can't be checked as instance has no address", ErrInstanceNotReady, port)
+	}
+
+	dialer := net.Dialer{Timeout: readinessCheckDialTimeout}
+
+	connection, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("%w: tcp port='%d' on address='%s' is not reachable: %w", ErrInstanceNotReady, port, address, err)
+	}
+
+	return connection.Close() //nolint:wrapcheck
+}
@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkTCPPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	port := listener.Addr().(*net.TCPAddr).Port //nolint:forcetypeassert
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			connection.Close()
+		}
+	}()
+
+	require.NoError(t, checkTCPPort(context.Background(), "127.0.0.1", port))
+
+	require.NoError(t, listener.Close())
+
+	err = checkTCPPort(context.Background(), "127.0.0.1", port)
+	require.ErrorIs(t, err, ErrInstanceNotReady)
+
+	// Empty address is not dialed on the local machine
+	err = checkTCPPort(context.Background(), "", port)
+	require.ErrorIs(t, err, ErrInstanceNotReady)
+}
+
+func TestInstanceGroup_checkInstanceReadiness_tcpPortAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	port := listener.Addr().(*net.TCPAddr).Port //nolint:forcetypeassert
+
+	tests := []struct {
+		name          string
+		addresses     instanceAddresses
+		expectedError error
+	}{
+		{name: "Internal address", addresses: instanceAddresses{internal: "127.0.0.1"}},
+		{name: "External address only", addresses: instanceAddresses{external: "127.0.0.1"}},
+		{name: "No address", addresses: instanceAddresses{}, expectedError: ErrInstanceNotReady},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.addresses.recordedAt = time.Now()
+
+			ig := InstanceGroup{
+				log:               hclog.NewNullLogger(),
+				Settings:          Settings{ReadinessCheck: ReadinessCheckSettings{TCPPort: port}},
+				instanceAddresses: map[uint64]instanceAddresses{100: testCase.addresses},
+			}
+
+			err := ig.checkInstanceReadiness(context.Background(), &proxmox.VirtualMachine{Node: "pve1", VMID: 100})
+			require.ErrorIs(t, err, testCase.expectedError)
+		})
+	}
+}
+
+// Starts fake QEMU guest agent which exits readiness command with given code and serves given files.
+func newReadinessTestServer(t *testing.T, exitCode *atomic.Int32, files map[string]string) *proxmox.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nodes/pve1/qemu/100/agent/exec":
+			_, _ = w.Write([]byte(`{"data":{"pid":42}}`))
+		case "/nodes/pve1/qemu/100/agent/exec-status":
+			_, _ = fmt.Fprintf(w, `{"data":{"exited":1,"exitcode":%d}}`, exitCode.Load())
+		case "/nodes/pve1/qemu/100/agent/file-read":
+			content, ok := files[r.URL.Query().Get("file")]
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": agentFileReadResult{Content: content}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return proxmox.NewClient(server.URL)
+}
+
+func TestInstanceGroup_checkInstanceReadiness(t *testing.T) {
+	tests := []struct {
+		name          string
+		settings      ReadinessCheckSettings
+		exitCode      int32
+		expectedError bool
+	}{
+		{name: "Command succeeded", settings: ReadinessCheckSettings{Exec: []string{"true"}}},
+		{name: "Command failed", settings: ReadinessCheckSettings{Exec: []string{"false"}}, exitCode: 1, expectedError: true},
+		{name: "File exists", settings: ReadinessCheckSettings{File: "/run/ready"}},
+		{name: "File missing", settings: ReadinessCheckSettings{File: "/run/missing"}, expectedError: true},
+		{name: "All checks must pass", settings: ReadinessCheckSettings{Exec: []string{"true"}, File: "/run/missing"}, expectedError: true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			var exitCode atomic.Int32
+			exitCode.Store(testCase.exitCode)
+
+			ig := InstanceGroup{
+				log:      hclog.NewNullLogger(),
+				proxmox:  newReadinessTestServer(t, &exitCode, map[string]string{"/run/ready": ""}),
+				Settings: Settings{ReadinessCheck: testCase.settings},
+			}
+			ig.Settings.FillWithDefaults()
+
+			err := ig.checkInstanceReadiness(context.Background(), &proxmox.VirtualMachine{Node: "pve1", VMID: 100})
+			if testCase.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestInstanceGroup_waitForInstanceReadiness(t *testing.T) {
+	var exitCode atomic.Int32
+	exitCode.Store(1)
+
+	ig := InstanceGroup{
+		log:     hclog.NewNullLogger(),
+		proxmox: newReadinessTestServer(t, &exitCode, map[string]string{}),
+		Settings: Settings{
+			ReadinessCheck: ReadinessCheckSettings{
+				Exec:     []string{"cloud-init", "status"},
+				Timeout:  Duration(5 * time.Second),
+				Interval: Duration(10 * time.Millisecond),
+			},
+		},
+	}
+
+	vm := &proxmox.VirtualMachine{Node: "pve1", VMID: 100}
+
+	// Checks are retried until they pass
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		exitCode.Store(0)
+	}()
+
+	require.NoError(t, ig.waitForInstanceReadiness(context.Background(), vm))
+
+	// Instance is not ready when checks don't pass within timeout
+	exitCode.Store(1)
+	ig.Settings.ReadinessCheck.Timeout = Duration(100 * time.Millisecond)
+
+	err := ig.waitForInstanceReadiness(context.Background(), vm)
+	require.ErrorIs(t, err, ErrInstanceNotReady)
+
+	// Readiness is not checked when no check is configured
+	ig.Settings.ReadinessCheck = ReadinessCheckSettings{}
+	require.NoError(t, ig.waitForInstanceReadiness(context.Background(), vm))
+}
@@ -1,8 +1,13 @@
 package plugin
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"time"
 )
 
 var (
@@ -24,45 +29,189 @@ const (
 	NetworkProtocolAny NetworkProtocol = "any"
 )
 
+type NetworkInterfaceMatch = string
+
+const (
+	// Interface name must be equal to instance_network_interface.
+	NetworkInterfaceMatchName NetworkInterfaceMatch = "name"
+
+	// Interface name must match instance_network_interface glob pattern, e.g. "en*".
+	NetworkInterfaceMatchGlob NetworkInterfaceMatch = "glob"
+
+	// Interface name must match instance_network_interface regular expression, e.g. "^(eth|en)".
+	NetworkInterfaceMatchRegex NetworkInterfaceMatch = "regex"
+
+	// Interface MAC address must be equal to MAC address of VM's net0 network device.
+	NetworkInterfaceMatchMAC NetworkInterfaceMatch = "mac"
+
+	// First interface which is not a loopback.
+	NetworkInterfaceMatchFirst NetworkInterfaceMatch = "first"
+)
+
+// Available policies for instances in the pool with unknown name.
+type OrphanPolicy = string
+
+const (
+	// Orphans are silently ignored.
+	OrphanPolicyIgnore OrphanPolicy = "ignore"
+
+	// Orphans are reported in logs.
+	OrphanPolicyReport OrphanPolicy = "report"
+
+	// Running orphans are renamed to running instance name and used as any other instance.
+	OrphanPolicyAdopt OrphanPolicy = "adopt"
+
+	// Orphans are marked for removal.
+	OrphanPolicyRemove OrphanPolicy = "remove"
+)
+
 // Default values for plugin settings.
 const (
 	DefaultInstanceNetworkInterface = "ens18"
 	DefaultInstanceNetworkProtocol  = NetworkProtocolIPv4
 
+	DefaultInstanceNetworkInterfaceMatch = NetworkInterfaceMatchName
+
 	DefaultInstanceNameCreating = "fleeting-creating"
 	DefaultInstanceNameRunning  = "fleeting-running"
 	DefaultInstanceNameRemoving = "fleeting-removing"
+
+	DefaultOrphanPolicy = OrphanPolicyIgnore
+
+	DefaultPreRemovalExecTimeout = Duration(1 * time.Minute)
+
+	DefaultDrainMaintenanceNodesTimeout = Duration(1 * time.Hour)
+
+	DefaultInstanceAddressTimeout = Duration(2 * time.Minute)
+
+	DefaultReadinessCheckTimeout  = Duration(5 * time.Minute)
+	DefaultReadinessCheckInterval = Duration(5 * time.Second)
+
+	DefaultFirewallAllowedDestinationsPolicyOut = "DROP"
 )
 
+// Actions of firewall rules and policies.
+var firewallActions = []string{"ACCEPT", "DROP", "REJECT"}
+
+// Instance group ID is stored as Proxmox VE tag so it must be a valid tag.
+var instanceGroupIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_+.-]*$`)
+
+// Duration which is represented in JSON as a string, e.g. "1m30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	//nolint:wrapcheck
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("failed to decode duration: %w", err)
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("failed to parse duration='%s': %w", value, err)
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
 // Plguin settings.
 type Settings struct {
 	// Proxmox VE URL.
 	URL string `json:"url"`
 
+	// URLs of multiple Proxmox VE cluster nodes, API calls fail over between them. Can't be used with URL.
+	URLs []string `json:"urls,omitempty"`
+
 	// If true then TLS certificate verification is disabled.
 	InsecureSkipTLSVerify bool `json:"insecure_skip_tls_verify"`
 
+	// Path to PEM bundle with CA certificates to verify Proxmox VE certificate with.
+	CACertPath string `json:"ca_cert_path,omitempty"`
+
+	// SHA-256 fingerprint of Proxmox VE certificate, if set then only this certificate is accepted.
+	TLSFingerprint string `json:"tls_fingerprint,omitempty"`
+
+	// Path to PEM encoded client certificate presented to Proxmox VE or proxy in front of it.
+	TLSClientCertPath string `json:"tls_client_cert_path,omitempty"`
+
+	// Path to PEM encoded private key of the client certificate.
+	TLSClientKeyPath string `json:"tls_client_key_path,omitempty"`
+
 	// Path to Proxmox VE credentials file.
 	CredentialsFilePath string `json:"credentials_file_path"`
 
+	// If true then credentials are read from PROXMOX_* environment variables, overriding ones from credentials file.
+	CredentialsFromEnv bool `json:"credentials_from_env"`
+
 	// Name of the Proxmox VE pool to use.
 	Pool string `json:"pool"`
 
 	// Name of the Proxmox VE storage to use.
 	Storage string `json:"storage"`
 
+	// Ratio of storage capacity that must remain free after cloning, if not set then storage capacity is not checked.
+	StorageMinFreeRatio *float64 `json:"storage_min_free_ratio,omitempty"`
+
+	// Maximum ratio of node's memory that can be allocated to VMs, if not set then memory is not checked.
+	NodeMaxMemoryRatio *float64 `json:"node_max_memory_ratio,omitempty"`
+
+	// Maximum ratio of node's CPUs that can be allocated to VMs, if not set then CPUs are not checked.
+	NodeMaxCPURatio *float64 `json:"node_max_cpu_ratio,omitempty"`
+
+	// If true then running instances on nodes in HA maintenance mode are reported as deleting and removed later.
+	DrainMaintenanceNodes bool `json:"drain_maintenance_nodes"`
+
+	// Time given to jobs running on drained instances to finish before instances are removed.
+	DrainMaintenanceNodesTimeout Duration `json:"drain_maintenance_nodes_timeout"`
+
 	// ID of the Proxmox VE VM to create instances from.
 	TemplateID *int `json:"template_id,omitempty"`
 
 	// Maximum instances than can be deployed.
 	MaxInstances *int `json:"max_instances,omitempty"`
 
+	// ID stamped as a tag on every instance, allows multiple plugin instances to share the pool and template.
+	InstanceGroupID string `json:"instance_group_id,omitempty"`
+
 	// Network interface to read instance's IP address from.
 	InstanceNetworkInterface string `json:"instance_network_interface"`
 
 	// Network protocol to look for when discovering instance's IP address.
 	InstanceNetworkProtocol NetworkProtocol `json:"instance_network_protocol"`
 
+	// How the network interface to read instance's IP address from is found.
+	InstanceNetworkInterfaceMatch NetworkInterfaceMatch `json:"instance_network_interface_match"`
+
+	// Maximum time to wait for newly deployed instance to get IP address.
+	InstanceAddressTimeout Duration `json:"instance_address_timeout"`
+
+	// Bridge to attach instance's network device to, overrides template's one.
+	NetworkBridge string `json:"network_bridge,omitempty"`
+
+	// VLAN tag of instance's network device, overrides template's one.
+	NetworkVLANTag *int `json:"network_vlan_tag,omitempty"`
+
+	// Model of instance's network device (e.g. virtio), overrides template's one.
+	NetworkModel string `json:"network_model,omitempty"`
+
+	// Rate limit of instance's network device in MB/s, overrides template's one.
+	NetworkRateLimit *float64 `json:"network_rate_limit,omitempty"`
+
+	// Prefix of randomly generated MAC address of instance's network device, e.g. BC:24:11.
+	NetworkMACPrefix string `json:"network_mac_prefix,omitempty"`
+
+	// CIDRs of instance's internal addresses, entries prefixed with "!" are excluded. Private addresses are internal if empty.
+	InternalAddressCIDRs []string `json:"internal_address_cidrs,omitempty"`
+
+	// CIDRs of instance's external addresses, entries prefixed with "!" are excluded. Public addresses are external if empty.
+	ExternalAddressCIDRs []string `json:"external_address_cidrs,omitempty"`
+
 	// Name to set for instances during creation.
 	InstanceNameCreating string `json:"instance_name_creating"`
 
@@ -71,6 +220,96 @@ type Settings struct {
 
 	// Name to set for instances during removal.
 	InstanceNameRemoving string `json:"instance_name_removing"`
+
+	// If true then QEMU guest agent of running instances is pinged during update and unresponsive instances are replaced.
+	HealthCheckAgent bool `json:"health_check_agent"`
+
+	// What to do with instances in the pool which name does not match any instance state.
+	OrphanPolicy OrphanPolicy `json:"orphan_policy"`
+
+	// Generate random password for connector's user of every instance with QEMU guest agent and return it in connector config.
+	InstancePassword bool `json:"instance_password"`
+
+	// Generate random password for Administrator of Windows instances with QEMU guest agent and return it in connector config.
+	WindowsAdministratorPassword bool `json:"windows_administrator_password"`
+
+	// Command to execute inside the instance with QEMU guest agent before it is removed.
+	PreRemovalExec []string `json:"pre_removal_exec,omitempty"`
+
+	// Maximum time to wait for pre-removal command to finish.
+	PreRemovalExecTimeout Duration `json:"pre_removal_exec_timeout"`
+
+	// Path to known hosts file updated with SSH host keys of instances read with QEMU guest agent.
+	KnownHostsPath string `json:"known_hosts_path,omitempty"`
+
+	// Path to a file with journal of in-flight operations, used to resume them after a crash.
+	StateJournalPath string `json:"state_journal_path,omitempty"`
+
+	// Checks that must pass before newly deployed instance is considered running.
+	ReadinessCheck ReadinessCheckSettings `json:"readiness_check"`
+
+	// Proxmox VE firewall configuration applied to every instance.
+	Firewall FirewallSettings `json:"firewall"`
+}
+
+// Firewall settings. Firewall is configured on every instance before it is started.
+type FirewallSettings struct {
+	// If true then Proxmox VE firewall is enabled for instance's network interface.
+	Enabled bool `json:"enabled"`
+
+	// Default policy for incoming traffic, ACCEPT, DROP or REJECT.
+	PolicyIn string `json:"policy_in,omitempty"`
+
+	// Default policy for outgoing traffic, ACCEPT, DROP or REJECT.
+	PolicyOut string `json:"policy_out,omitempty"`
+
+	// Name of cluster's security group to apply to the instance.
+	SecurityGroup string `json:"security_group,omitempty"`
+
+	// Rules added to the instance, evaluated in given order after security group.
+	Rules []FirewallRule `json:"rules,omitempty"`
+
+	// Addresses or CIDRs which instance is allowed to connect to, added as an IP set with accepting rule.
+	AllowedDestinations []string `json:"allowed_destinations,omitempty"`
+}
+
+// Firewall rule, see Proxmox VE documentation for format of the fields.
+type FirewallRule struct {
+	// Direction of the traffic, in or out.
+	Type string `json:"type"`
+
+	// Action applied to matching traffic, ACCEPT, DROP or REJECT.
+	Action string `json:"action"`
+
+	Macro  string `json:"macro,omitempty"`
+	Source string `json:"source,omitempty"`
+	Dest   string `json:"dest,omitempty"`
+	Proto  string `json:"proto,omitempty"`
+	Dport  string `json:"dport,omitempty"`
+	Sport  string `json:"sport,omitempty"`
+}
+
+// Readiness check settings. All configured checks must pass for the instance to be ready.
+type ReadinessCheckSettings struct {
+	// Command to execute inside the instance with QEMU guest agent, must exit with zero code.
+	Exec []string `json:"exec,omitempty"`
+
+	// Path to a file inside the instance that must exist, read with QEMU guest agent.
+	File string `json:"file,omitempty"`
+
+	// TCP port that must accept connections on instance's internal address.
+	TCPPort int `json:"tcp_port,omitempty"`
+
+	// Maximum time to wait for the instance to become ready.
+	Timeout Duration `json:"timeout"`
+
+	// Time between readiness check attempts.
+	Interval Duration `json:"interval"`
+}
+
+// Returns true if any readiness check is configured.
+func (s *ReadinessCheckSettings) IsEnabled() bool {
+	return len(s.Exec) > 0 || s.File != "" || s.TCPPort != 0
 }
 
 func (s *Settings) FillWithDefaults() {
@@ -82,6 +321,10 @@ func (s *Settings) FillWithDefaults() {
 		s.InstanceNetworkProtocol = DefaultInstanceNetworkProtocol
 	}
 
+	if s.InstanceNetworkInterfaceMatch == "" {
+		s.InstanceNetworkInterfaceMatch = DefaultInstanceNetworkInterfaceMatch
+	}
+
 	if s.InstanceNameCreating == "" {
 		s.InstanceNameCreating = DefaultInstanceNameCreating
 	}
@@ -97,15 +340,52 @@ func (s *Settings) FillWithDefaults() {
 	if s.InstanceNetworkProtocol == "" {
 		s.InstanceNetworkProtocol = DefaultInstanceNetworkProtocol
 	}
+
+	if s.OrphanPolicy == "" {
+		s.OrphanPolicy = DefaultOrphanPolicy
+	}
+
+	if s.PreRemovalExecTimeout == 0 {
+		s.PreRemovalExecTimeout = DefaultPreRemovalExecTimeout
+	}
+
+	if s.DrainMaintenanceNodesTimeout == 0 {
+		s.DrainMaintenanceNodesTimeout = DefaultDrainMaintenanceNodesTimeout
+	}
+
+	if s.InstanceAddressTimeout == 0 {
+		s.InstanceAddressTimeout = DefaultInstanceAddressTimeout
+	}
+
+	// Only allowed destinations should be reachable
+	if len(s.Firewall.AllowedDestinations) > 0 && s.Firewall.PolicyOut == "" {
+		s.Firewall.PolicyOut = DefaultFirewallAllowedDestinationsPolicyOut
+	}
+
+	if s.ReadinessCheck.Timeout == 0 {
+		s.ReadinessCheck.Timeout = DefaultReadinessCheckTimeout
+	}
+
+	if s.ReadinessCheck.Interval == 0 {
+		s.ReadinessCheck.Interval = DefaultReadinessCheckInterval
+	}
 }
 
 func (s *Settings) CheckRequiredFields() error {
-	if s.URL == "" {
+	if s.URL == "" && len(s.URLs) < 1 {
 		return fmt.Errorf("%w: url", ErrRequiredSettingMissing)
 	}
 
-	if s.CredentialsFilePath == "" {
-		return fmt.Errorf("%w: credentials_file_path", ErrRequiredSettingMissing)
+	if s.URL != "" && len(s.URLs) > 0 {
+		return fmt.Errorf("%w: url: can't be used with urls", ErrSettingInvalidParameter)
+	}
+
+	if s.CredentialsFilePath == "" && !s.CredentialsFromEnv {
+		return fmt.Errorf("%w: credentials_file_path or credentials_from_env", ErrRequiredSettingMissing)
+	}
+
+	if err := s.checkTLSFields(); err != nil {
+		return err
 	}
 
 	if s.Pool == "" {
@@ -124,5 +404,152 @@ func (s *Settings) CheckRequiredFields() error {
 		return fmt.Errorf("%w: instance_network_protocol: must be ipv4, ipv6 or any", ErrSettingInvalidParameter)
 	}
 
+	if s.InstanceGroupID != "" && !instanceGroupIDPattern.MatchString(s.InstanceGroupID) {
+		return fmt.Errorf("%w: instance_group_id: must be a valid Proxmox VE tag", ErrSettingInvalidParameter)
+	}
+
+	if s.StorageMinFreeRatio != nil && (*s.StorageMinFreeRatio < 0 || *s.StorageMinFreeRatio >= 1) {
+		return fmt.Errorf("%w: storage_min_free_ratio: must be between 0 and 1", ErrSettingInvalidParameter)
+	}
+
+	if (s.NodeMaxMemoryRatio != nil && *s.NodeMaxMemoryRatio <= 0) || (s.NodeMaxCPURatio != nil && *s.NodeMaxCPURatio <= 0) {
+		return fmt.Errorf("%w: node_max_memory_ratio and node_max_cpu_ratio: must be greater than 0", ErrSettingInvalidParameter)
+	}
+
+	if !slices.Contains([]OrphanPolicy{"", OrphanPolicyIgnore, OrphanPolicyReport, OrphanPolicyAdopt, OrphanPolicyRemove}, s.OrphanPolicy) {
+		return fmt.Errorf("%w: orphan_policy: must be ignore, report, adopt or remove", ErrSettingInvalidParameter)
+	}
+
+	if s.PreRemovalExecTimeout < 0 {
+		return fmt.Errorf("%w: pre_removal_exec_timeout: must not be negative", ErrSettingInvalidParameter)
+	}
+
+	if s.DrainMaintenanceNodesTimeout < 0 {
+		return fmt.Errorf("%w: drain_maintenance_nodes_timeout: must not be negative", ErrSettingInvalidParameter)
+	}
+
+	if err := s.checkNetworkFields(); err != nil {
+		return err
+	}
+
+	if err := s.Firewall.CheckRequiredFields(); err != nil {
+		return err
+	}
+
+	if err := s.ReadinessCheck.CheckRequiredFields(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Returns URLs of all configured Proxmox VE API endpoints.
+func (s *Settings) GetURLs() []string {
+	if len(s.URLs) > 0 {
+		return s.URLs
+	}
+
+	return []string{s.URL}
+}
+
+func (s *Settings) checkNetworkFields() error {
+	if err := checkNetworkInterfaceMatch(s.InstanceNetworkInterfaceMatch, s.InstanceNetworkInterface); err != nil {
+		return fmt.Errorf("%w: instance_network_interface_match: %w", ErrSettingInvalidParameter, err)
+	}
+
+	if s.InstanceAddressTimeout < 0 {
+		return fmt.Errorf("%w: instance_address_timeout: must not be negative", ErrSettingInvalidParameter)
+	}
+
+	if s.NetworkVLANTag != nil && (*s.NetworkVLANTag < 1 || *s.NetworkVLANTag > 4094) {
+		return fmt.Errorf("%w: network_vlan_tag: must be between 1 and 4094", ErrSettingInvalidParameter)
+	}
+
+	if s.NetworkModel != "" && !slices.Contains(networkModels, s.NetworkModel) {
+		return fmt.Errorf("%w: network_model: must be one of %v", ErrSettingInvalidParameter, networkModels)
+	}
+
+	if s.NetworkRateLimit != nil && *s.NetworkRateLimit <= 0 {
+		return fmt.Errorf("%w: network_rate_limit: must be greater than 0", ErrSettingInvalidParameter)
+	}
+
+	if s.NetworkMACPrefix != "" {
+		if _, err := parseMACPrefix(s.NetworkMACPrefix); err != nil {
+			return fmt.Errorf("%w: network_mac_prefix: %w", ErrSettingInvalidParameter, err)
+		}
+	}
+
+	if _, err := parseAddressCIDRs(s.InternalAddressCIDRs); err != nil {
+		return fmt.Errorf("%w: internal_address_cidrs: %w", ErrSettingInvalidParameter, err)
+	}
+
+	if _, err := parseAddressCIDRs(s.ExternalAddressCIDRs); err != nil {
+		return fmt.Errorf("%w: external_address_cidrs: %w", ErrSettingInvalidParameter, err)
+	}
+
+	return nil
+}
+
+func (s *Settings) checkTLSFields() error {
+	if s.InsecureSkipTLSVerify && (s.CACertPath != "" || s.TLSFingerprint != "") {
+		return fmt.Errorf("%w: insecure_skip_tls_verify: can't be used with ca_cert_path or tls_fingerprint", ErrSettingInvalidParameter)
+	}
+
+	if (s.TLSClientCertPath == "") != (s.TLSClientKeyPath == "") {
+		return fmt.Errorf("%w: tls_client_cert_path and tls_client_key_path must be set together", ErrSettingInvalidParameter)
+	}
+
+	if s.TLSFingerprint != "" {
+		if _, err := parseTLSFingerprint(s.TLSFingerprint); err != nil {
+			return fmt.Errorf("%w: tls_fingerprint: %w", ErrSettingInvalidParameter, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *FirewallSettings) CheckRequiredFields() error {
+	if !s.Enabled {
+		if s.PolicyIn != "" || s.PolicyOut != "" || s.SecurityGroup != "" || len(s.Rules) > 0 || len(s.AllowedDestinations) > 0 {
+			return fmt.Errorf("%w: firewall.enabled: must be true when firewall is configured", ErrSettingInvalidParameter)
+		}
+
+		return nil
+	}
+
+	for _, policy := range []string{s.PolicyIn, s.PolicyOut} {
+		if policy != "" && !slices.Contains(firewallActions, policy) {
+			return fmt.Errorf("%w: firewall: policy='%s' must be one of %v", ErrSettingInvalidParameter, policy, firewallActions)
+		}
+	}
+
+	for i, rule := range s.Rules {
+		if rule.Type != "in" && rule.Type != "out" {
+			return fmt.Errorf("%w: firewall.rules[%d].type: must be in or out", ErrSettingInvalidParameter, i)
+		}
+
+		if !slices.Contains(firewallActions, rule.Action) {
+			return fmt.Errorf("%w: firewall.rules[%d].action: must be one of %v", ErrSettingInvalidParameter, i, firewallActions)
+		}
+	}
+
+	for _, destination := range s.AllowedDestinations {
+		if _, _, err := net.ParseCIDR(destination); err != nil && net.ParseIP(destination) == nil {
+			return fmt.Errorf("%w: firewall.allowed_destinations: '%s' is not an IP address or CIDR", ErrSettingInvalidParameter, destination)
+		}
+	}
+
+	return nil
+}
+
+func (s *ReadinessCheckSettings) CheckRequiredFields() error {
+	if s.TCPPort < 0 || s.TCPPort > 65535 {
+		return fmt.Errorf("%w: readiness_check.tcp_port: must be between 1 and 65535", ErrSettingInvalidParameter)
+	}
+
+	if s.Timeout < 0 || s.Interval < 0 {
+		return fmt.Errorf("%w: readiness_check: timeout and interval must not be negative", ErrSettingInvalidParameter)
+	}
+
 	return nil
 }
@@ -1,7 +1,9 @@
 package plugin
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -15,6 +17,9 @@ var (
 	sampleStorage              = "sample_storage"
 	sampleTemplateID           = 20
 	sampleMaxInstances         = 7
+	sampleInvalidRatio         = 1.5
+	sampleNegativeRatio        = -0.5
+	sampleInvalidVLANTag       = 4095
 	sampleInstanceNameCreating = "proxmox-creating"
 	sampleInstanceNameRunning  = "running-prox"
 	sampleInstanceNameRemoving = "proxve-removing"
@@ -31,6 +36,21 @@ func TestSettings_fillWithDefaults(t *testing.T) {
 	require.Equal(t, "fleeting-removing", settings.InstanceNameRemoving)
 	require.Equal(t, "ens18", settings.InstanceNetworkInterface)
 	require.Equal(t, "ipv4", settings.InstanceNetworkProtocol)
+	require.Equal(t, "name", settings.InstanceNetworkInterfaceMatch)
+	require.Equal(t, Duration(2*time.Minute), settings.InstanceAddressTimeout)
+	require.Equal(t, "ignore", settings.OrphanPolicy)
+	require.Equal(t, Duration(1*time.Minute), settings.PreRemovalExecTimeout)
+	require.Equal(t, Duration(1*time.Hour), settings.DrainMaintenanceNodesTimeout)
+	require.Equal(t, Duration(5*time.Minute), settings.ReadinessCheck.Timeout)
+	require.Equal(t, Duration(5*time.Second), settings.ReadinessCheck.Interval)
+	require.False(t, settings.ReadinessCheck.IsEnabled())
+	require.False(t, settings.Firewall.Enabled)
+	require.Empty(t, settings.Firewall.PolicyOut)
+
+	// Outgoing traffic is dropped by default when allowed destinations are set
+	settingsWithFirewall := Settings{Firewall: FirewallSettings{Enabled: true, AllowedDestinations: []string{"10.0.0.0/8"}}}
+	settingsWithFirewall.FillWithDefaults()
+	require.Equal(t, "DROP", settingsWithFirewall.Firewall.PolicyOut)
 
 	settings2 := Settings{
 		InstanceNameCreating: sampleInstanceNameCreating,
@@ -72,6 +92,18 @@ func TestSettings_checkRequiredFields(t *testing.T) {
 			},
 			expectedError: ErrRequiredSettingMissing,
 		},
+		{
+			name: "Credentials from env",
+			settings: Settings{
+				URL:                sampleURL,
+				CredentialsFromEnv: true,
+				Pool:               samplePool,
+				Storage:            sampleStorage,
+				TemplateID:         &sampleTemplateID,
+				MaxInstances:       &sampleMaxInstances,
+			},
+			expectedError: nil,
+		},
 		{
 			name: "Missing pool",
 			settings: Settings{
@@ -143,6 +175,295 @@ func TestSettings_checkRequiredFields(t *testing.T) {
 			},
 			expectedError: ErrSettingInvalidParameter,
 		},
+		{
+			name: "Negative pre-removal exec timeout",
+			settings: Settings{
+				URL:                   sampleURL,
+				CredentialsFilePath:   sampleCredentialsPath,
+				Pool:                  samplePool,
+				Storage:               sampleStorage,
+				TemplateID:            &sampleTemplateID,
+				MaxInstances:          &sampleMaxInstances,
+				PreRemovalExecTimeout: Duration(-1 * time.Second),
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Negative drain maintenance nodes timeout",
+			settings: Settings{
+				URL:                          sampleURL,
+				CredentialsFilePath:          sampleCredentialsPath,
+				Pool:                         samplePool,
+				Storage:                      sampleStorage,
+				TemplateID:                   &sampleTemplateID,
+				MaxInstances:                 &sampleMaxInstances,
+				DrainMaintenanceNodesTimeout: Duration(-1 * time.Second),
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid instance group id",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				InstanceGroupID:     "runners;manager-1",
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Multiple URLs",
+			settings: Settings{
+				URLs:                []string{sampleURL, sampleURL},
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+			},
+			expectedError: nil,
+		},
+		{
+			name: "Both URL and URLs",
+			settings: Settings{
+				URL:                 sampleURL,
+				URLs:                []string{sampleURL},
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid TLS fingerprint",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				TLSFingerprint:      "AB:CD",
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Insecure TLS with CA bundle",
+			settings: Settings{
+				URL:                   sampleURL,
+				CredentialsFilePath:   sampleCredentialsPath,
+				InsecureSkipTLSVerify: true,
+				CACertPath:            "/etc/ssl/proxmox.pem",
+				Pool:                  samplePool,
+				Storage:               sampleStorage,
+				TemplateID:            &sampleTemplateID,
+				MaxInstances:          &sampleMaxInstances,
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "TLS client certificate without key",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				TLSClientCertPath:   "/etc/ssl/client.pem",
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Firewall rules without enabled firewall",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				Firewall:            FirewallSettings{Rules: []FirewallRule{{Type: "in", Action: "ACCEPT"}}},
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid firewall rule action",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				Firewall:            FirewallSettings{Enabled: true, Rules: []FirewallRule{{Type: "in", Action: "ALLOW"}}},
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid firewall policy",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				Firewall:            FirewallSettings{Enabled: true, PolicyOut: "deny"},
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid firewall allowed destination",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				Firewall:            FirewallSettings{Enabled: true, AllowedDestinations: []string{"example.com"}},
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid network VLAN tag",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				NetworkVLANTag:      &sampleInvalidVLANTag,
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid network model",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				NetworkModel:        "virtio-net",
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid network MAC prefix",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				NetworkMACPrefix:    "01:23",
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid network interface match",
+			settings: Settings{
+				URL:                           sampleURL,
+				CredentialsFilePath:           sampleCredentialsPath,
+				Pool:                          samplePool,
+				Storage:                       sampleStorage,
+				TemplateID:                    &sampleTemplateID,
+				MaxInstances:                  &sampleMaxInstances,
+				InstanceNetworkInterfaceMatch: "nic",
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid network interface regex",
+			settings: Settings{
+				URL:                           sampleURL,
+				CredentialsFilePath:           sampleCredentialsPath,
+				Pool:                          samplePool,
+				Storage:                       sampleStorage,
+				TemplateID:                    &sampleTemplateID,
+				MaxInstances:                  &sampleMaxInstances,
+				InstanceNetworkInterface:      "^(eth",
+				InstanceNetworkInterfaceMatch: "regex",
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid external address CIDR",
+			settings: Settings{
+				URL:                  sampleURL,
+				CredentialsFilePath:  sampleCredentialsPath,
+				Pool:                 samplePool,
+				Storage:              sampleStorage,
+				TemplateID:           &sampleTemplateID,
+				MaxInstances:         &sampleMaxInstances,
+				ExternalAddressCIDRs: []string{"10.50.0.0/16", "!172.17.0.0"},
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid storage min free ratio",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				StorageMinFreeRatio: &sampleInvalidRatio,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid node max memory ratio",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				NodeMaxMemoryRatio:  &sampleNegativeRatio,
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid orphan policy",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				OrphanPolicy:        "delete",
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
+		{
+			name: "Invalid readiness check port",
+			settings: Settings{
+				URL:                 sampleURL,
+				CredentialsFilePath: sampleCredentialsPath,
+				Pool:                samplePool,
+				Storage:             sampleStorage,
+				TemplateID:          &sampleTemplateID,
+				MaxInstances:        &sampleMaxInstances,
+				ReadinessCheck: ReadinessCheckSettings{
+					TCPPort: 70000,
+				},
+			},
+			expectedError: ErrSettingInvalidParameter,
+		},
 	}
 
 	for _, tt := range tests {
@@ -152,3 +473,17 @@ func TestSettings_checkRequiredFields(t *testing.T) {
 		})
 	}
 }
+
+func TestDuration_json(t *testing.T) {
+	var duration Duration
+
+	require.NoError(t, json.Unmarshal([]byte(`"1m30s"`), &duration))
+	require.Equal(t, Duration(90*time.Second), duration)
+
+	encoded, err := json.Marshal(duration)
+	require.NoError(t, err)
+	require.JSONEq(t, `"1m30s"`, string(encoded))
+
+	require.Error(t, json.Unmarshal([]byte(`"invalid"`), &duration))
+	require.Error(t, json.Unmarshal([]byte(`90`), &duration))
+}
@@ -0,0 +1,188 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+var (
+	ErrInsufficientStorage = errors.New("insufficient free space on storage")
+	ErrInvalidDiskSize     = errors.New("invalid disk size")
+)
+
+// Returns how many out of requested instances can be cloned without free space on any storage used by clones dropping
+// below the threshold. Full clones use configured storage, linked clones use storages of the template's disks.
+func (ig *InstanceGroup) capInstancesByStorage(ctx context.Context, template *proxmox.VirtualMachine, count int) (int, error) {
+	if ig.Settings.StorageMinFreeRatio == nil {
+		return count, nil
+	}
+
+	diskSizes, err := templateDiskSizes(template, ig.Settings.Storage)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(diskSizes) == 0 {
+		return count, nil
+	}
+
+	node, err := ig.proxmox.Node(ctx, template.Node)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get node='%s': %w", template.Node, err)
+	}
+
+	storageNames := make([]string, 0, len(diskSizes))
+	for storageName := range diskSizes {
+		storageNames = append(storageNames, storageName)
+	}
+
+	slices.Sort(storageNames)
+
+	fitting := count
+
+	for _, storageName := range storageNames {
+		fitting, err = ig.capInstancesByStorageSpace(ctx, node, storageName, diskSizes[storageName], fitting)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if fitting < count {
+		ig.log.Warn("not enough free space on storage for all requested instances", "requested", count, "allowed", fitting)
+	}
+
+	return fitting, nil
+}
+
+// Returns how many out of requested instances fit the storage, given size of instance's disks on it.
+func (ig *InstanceGroup) capInstancesByStorageSpace(ctx context.Context, node *proxmox.Node, storageName string, diskSize uint64, count int) (int, error) {
+	storage, err := node.Storage(ctx, storageName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get status of storage='%s' on node='%s': %w", storageName, node.Name, err)
+	}
+
+	fitting := instancesFittingStorage(storage.Avail, storage.Total, diskSize, *ig.Settings.StorageMinFreeRatio, count)
+
+	if fitting < 1 {
+		return 0, fmt.Errorf("%w: storage='%s' available='%d' total='%d' required per instance='%d'", ErrInsufficientStorage, storageName, storage.Avail, storage.Total, diskSize)
+	}
+
+	if fitting < count {
+		ig.log.Warn("not enough free space on storage for all requested instances", "storage", storageName, "requested", count, "allowed", fitting)
+	}
+
+	return fitting, nil
+}
+
+// Calculates how many instances of given size can be created while keeping free at least given ratio of total capacity.
+func instancesFittingStorage(available, total, perInstance uint64, minFreeRatio float64, count int) int {
+	reserved := uint64(math.Ceil(float64(total) * minFreeRatio))
+
+	if available <= reserved {
+		return 0
+	}
+
+	if perInstance == 0 {
+		return count
+	}
+
+	fitting := (available - reserved) / perInstance
+
+	if fitting < uint64(count) {
+		return int(fitting)
+	}
+
+	return count
+}
+
+// Sums sizes of template's disks, excluding CD-ROMs, per storage the clone's disks are created on. Disks are created on
+// target storage if set (full clone), otherwise on the storage of the template's disk (linked clone). Linked clones
+// are counted with full size of the template's disks, as they can grow up to it. Unused disks are not cloned.
+func templateDiskSizes(template *proxmox.VirtualMachine, targetStorage string) (map[string]uint64, error) {
+	sizes := map[string]uint64{}
+
+	config := template.VirtualMachineConfig
+	if config == nil {
+		return sizes, nil
+	}
+
+	disks := config.MergeIDEs()
+	maps.Copy(disks, config.MergeSATAs())
+	maps.Copy(disks, config.MergeSCSIs())
+	maps.Copy(disks, config.MergeVirtIOs())
+
+	if config.EFIDisk0 != "" {
+		disks["efidisk0"] = config.EFIDisk0
+	}
+
+	if config.TPMState0 != "" {
+		disks["tpmstate0"] = config.TPMState0
+	}
+
+	for name, disk := range disks {
+		options := strings.Split(disk, ",")
+
+		if slices.Contains(options, "media=cdrom") {
+			continue
+		}
+
+		storage, _, found := strings.Cut(options[0], ":")
+		if !found {
+			continue
+		}
+
+		if targetStorage != "" {
+			storage = targetStorage
+		}
+
+		for _, option := range options[1:] {
+			value, found := strings.CutPrefix(option, "size=")
+			if !found {
+				continue
+			}
+
+			size, err := parseProxmoxDiskSize(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse size of disk='%s': %w", name, err)
+			}
+
+			sizes[storage] += size
+		}
+	}
+
+	return sizes, nil
+}
+
+// Parses disk size as written in Proxmox VE VM config, e.g. "32G".
+func parseProxmoxDiskSize(size string) (uint64, error) {
+	multiplier := uint64(1)
+
+	units := map[byte]uint64{
+		'K': 1 << 10,
+		'M': 1 << 20,
+		'G': 1 << 30,
+		'T': 1 << 40,
+	}
+
+	if size != "" {
+		if unitMultiplier, ok := units[size[len(size)-1]]; ok {
+			multiplier = unitMultiplier
+			size = size[:len(size)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%w: '%s'", ErrInvalidDiskSize, size)
+	}
+
+	return uint64(math.Ceil(value * float64(multiplier))), nil
+}
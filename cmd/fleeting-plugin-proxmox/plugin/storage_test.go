@@ -0,0 +1,141 @@
+package plugin
+
+import (
+	"context"
+
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_instancesFittingStorage(t *testing.T) {
+	tests := []struct {
+		name         string
+		available    uint64
+		total        uint64
+		perInstance  uint64
+		minFreeRatio float64
+		count        int
+		expected     int
+	}{
+		{name: "All fit", available: 100, total: 100, perInstance: 10, minFreeRatio: 0, count: 5, expected: 5},
+		{name: "Capped", available: 35, total: 100, perInstance: 10, minFreeRatio: 0, count: 5, expected: 3},
+		{name: "Capped by threshold", available: 50, total: 100, perInstance: 10, minFreeRatio: 0.2, count: 5, expected: 3},
+		{name: "Below threshold", available: 10, total: 100, perInstance: 1, minFreeRatio: 0.1, count: 5, expected: 0},
+		{name: "Zero sized template", available: 50, total: 100, perInstance: 0, minFreeRatio: 0.1, count: 5, expected: 5},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.expected, instancesFittingStorage(testCase.available, testCase.total, testCase.perInstance, testCase.minFreeRatio, testCase.count))
+		})
+	}
+}
+
+func Test_parseProxmoxDiskSize(t *testing.T) {
+	tests := map[string]uint64{
+		"512":  512,
+		"4K":   4 << 10,
+		"528K": 528 << 10,
+		"4M":   4 << 20,
+		"32G":  32 << 30,
+		"1.5G": 3 << 29,
+		"2T":   2 << 40,
+	}
+
+	for input, expected := range tests {
+		size, err := parseProxmoxDiskSize(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, size, input)
+	}
+
+	_, err := parseProxmoxDiskSize("")
+	require.ErrorIs(t, err, ErrInvalidDiskSize)
+
+	_, err = parseProxmoxDiskSize("G")
+	require.ErrorIs(t, err, ErrInvalidDiskSize)
+}
+
+func Test_templateDiskSizes(t *testing.T) {
+	template := &proxmox.VirtualMachine{
+		VirtualMachineConfig: &proxmox.VirtualMachineConfig{
+			SCSI0:     "local-lvm:base-100-disk-0,discard=on,size=32G",
+			SCSI1:     "fast:base-100-disk-1,size=8G",
+			IDE2:      "local-lvm:vm-100-cloudinit,media=cdrom",
+			SATA0:     "none,media=cdrom",
+			EFIDisk0:  "local-lvm:base-100-disk-2,efitype=4m,pre-enrolled-keys=1,size=4M",
+			TPMState0: "local-lvm:base-100-disk-3,size=4M,version=v2.0",
+		},
+	}
+
+	tests := []struct {
+		name          string
+		targetStorage string
+		expected      map[string]uint64
+	}{
+		{
+			name:          "Full clone",
+			targetStorage: sampleStorage,
+			expected:      map[string]uint64{sampleStorage: 40<<30 + 8<<20},
+		},
+		{
+			name:          "Linked clone",
+			targetStorage: "",
+			expected:      map[string]uint64{"local-lvm": 32<<30 + 8<<20, "fast": 8 << 30},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			sizes, err := templateDiskSizes(template, testCase.targetStorage)
+			require.NoError(t, err)
+			require.Equal(t, testCase.expected, sizes)
+		})
+	}
+
+	sizes, err := templateDiskSizes(&proxmox.VirtualMachine{}, sampleStorage)
+	require.NoError(t, err)
+	require.Empty(t, sizes)
+}
+
+func TestInstanceGroup_capInstancesByStorage_linkedClone(t *testing.T) {
+	minFreeRatio := 0.1
+
+	ig := InstanceGroup{
+		log:      hclog.NewNullLogger(),
+		Settings: Settings{StorageMinFreeRatio: &minFreeRatio},
+	}
+
+	client, _ := newRecordingServer(t, map[string]string{
+		"/nodes/pve1/status":                   `{}`,
+		"/nodes/pve1/storage/local-lvm/status": `{"avail":100,"total":100}`,
+		"/nodes/pve1/storage/fast/status":      `{"avail":40,"total":100}`,
+	})
+	ig.proxmox = client
+
+	template := &proxmox.VirtualMachine{
+		Node: "pve1",
+		VirtualMachineConfig: &proxmox.VirtualMachineConfig{
+			SCSI0:    "local-lvm:base-100-disk-0,size=10",
+			SCSI1:    "fast:base-100-disk-1,size=10",
+			EFIDisk0: "local-lvm:base-100-disk-2,size=10",
+		},
+	}
+
+	// Template's storages are checked, the one with the least free space caps the count
+	count, err := ig.capInstancesByStorage(context.Background(), template, 5)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	client, _ = newRecordingServer(t, map[string]string{
+		"/nodes/pve1/status":                   `{}`,
+		"/nodes/pve1/storage/local-lvm/status": `{"avail":100,"total":100}`,
+		"/nodes/pve1/storage/fast/status":      `{"avail":5,"total":100}`,
+	})
+	ig.proxmox = client
+
+	_, err = ig.capInstancesByStorage(context.Background(), template, 5)
+	require.ErrorIs(t, err, ErrInsufficientStorage)
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// Task status is polled often at first, as most tasks finish within seconds, and less often the longer it runs.
+const (
+	proxmoxTaskWaitInitialInterval = 250 * time.Millisecond
+	proxmoxTaskWaitMaxInterval     = 5 * time.Second
+)
+
+var ErrTaskFailed = errors.New("proxmox task failed")
+
+// Waits for the task to finish and fails if it did not finish successfully.
+func waitForTask(ctx context.Context, task *proxmox.Task, timeout time.Duration) error {
+	// Synchronous operations return no task
+	if task == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	interval := proxmoxTaskWaitInitialInterval
+
+	for {
+		if err := task.Ping(ctx); err != nil {
+			return fmt.Errorf("failed to get status of task='%s': %w", task.UPID, err)
+		}
+
+		if task.IsCompleted {
+			// Tasks which finished with warnings are still successful
+			if task.IsFailed && !strings.HasPrefix(task.ExitStatus, "WARNINGS") {
+				return fmt.Errorf("%w: task='%s' exit status='%s'", ErrTaskFailed, task.UPID, task.ExitStatus)
+			}
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to wait for task='%s': %w", task.UPID, ctx.Err())
+		case <-time.After(interval):
+		}
+
+		interval = min(interval*2, proxmoxTaskWaitMaxInterval)
+	}
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleUPID = "UPID:pve1:00001234:00005678:65000000:qmclone:100:root@pam:"
+
+// Starts fake Proxmox VE API which reports the task as running for given number of polls.
+// Responses must contain UPID and node as go-proxmox overwrites the task with the response.
+func newTaskStatusServer(t *testing.T, runningPolls int32, exitStatus string) (*proxmox.Client, *atomic.Int32) {
+	t.Helper()
+
+	var polls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if polls.Add(1) <= runningPolls {
+			_, _ = fmt.Fprintf(w, `{"data":{"upid":"%s","node":"pve1","status":"running"}}`, sampleUPID)
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, `{"data":{"upid":"%s","node":"pve1","status":"stopped","exitstatus":"%s"}}`, sampleUPID, exitStatus)
+	}))
+	t.Cleanup(server.Close)
+
+	return proxmox.NewClient(server.URL), &polls
+}
+
+func Test_waitForTask(t *testing.T) {
+	client, polls := newTaskStatusServer(t, 2, "OK")
+
+	started := time.Now()
+	require.NoError(t, waitForTask(context.Background(), proxmox.NewTask(sampleUPID, client), time.Minute))
+	require.Equal(t, int32(3), polls.Load())
+	require.Less(t, time.Since(started), 2*time.Second)
+}
+
+func Test_waitForTask_warnings(t *testing.T) {
+	client, _ := newTaskStatusServer(t, 0, "WARNINGS: 1")
+
+	require.NoError(t, waitForTask(context.Background(), proxmox.NewTask(sampleUPID, client), time.Minute))
+}
+
+func Test_waitForTask_failed(t *testing.T) {
+	client, _ := newTaskStatusServer(t, 0, "clone failed")
+
+	err := waitForTask(context.Background(), proxmox.NewTask(sampleUPID, client), time.Minute)
+	require.ErrorIs(t, err, ErrTaskFailed)
+}
+
+func Test_waitForTask_timeout(t *testing.T) {
+	client, _ := newTaskStatusServer(t, 1000, "OK")
+
+	err := waitForTask(context.Background(), proxmox.NewTask(sampleUPID, client), 500*time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func Test_waitForTask_noTask(t *testing.T) {
+	require.NoError(t, waitForTask(context.Background(), nil, time.Minute))
+}
@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTLSFingerprintMismatch = errors.New("TLS certificate fingerprint mismatch")
+	ErrTLSFingerprintInvalid  = errors.New("invalid TLS certificate fingerprint")
+	ErrTLSCACertInvalid       = errors.New("no valid certificates found in CA bundle")
+)
+
+// Returns TLS configuration for Proxmox VE API client.
+func (ig *InstanceGroup) getTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		//nolint:gosec
+		InsecureSkipVerify: ig.Settings.InsecureSkipTLSVerify,
+	}
+
+	if ig.Settings.CACertPath != "" {
+		pem, err := os.ReadFile(ig.Settings.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle from path='%s': %w", ig.Settings.CACertPath, err)
+		}
+
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: path='%s'", ErrTLSCACertInvalid, ig.Settings.CACertPath)
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if ig.Settings.TLSFingerprint != "" {
+		fingerprint, err := parseTLSFingerprint(ig.Settings.TLSFingerprint)
+		if err != nil {
+			return nil, err
+		}
+
+		// Pinned certificate is trusted on its own, unless CA bundle is configured as well
+		if ig.Settings.CACertPath == "" {
+			tlsConfig.InsecureSkipVerify = true
+		}
+
+		tlsConfig.VerifyConnection = verifyTLSFingerprint(fingerprint)
+	}
+
+	if ig.Settings.TLSClientCertPath != "" {
+		clientCertificate := &clientCertificate{
+			certPath: ig.Settings.TLSClientCertPath,
+			keyPath:  ig.Settings.TLSClientKeyPath,
+		}
+
+		// Fail early if configured pair can't be loaded at all
+		if _, err := clientCertificate.get(); err != nil {
+			return nil, err
+		}
+
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return clientCertificate.get()
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// Parses SHA-256 fingerprint in format used by Proxmox VE, i.e. hex bytes optionally separated with colons.
+func parseTLSFingerprint(fingerprint string) ([]byte, error) {
+	decoded, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+	if err != nil || len(decoded) != sha256.Size {
+		return nil, fmt.Errorf("%w: must be SHA-256 fingerprint, e.g. 'AB:CD:...'", ErrTLSFingerprintInvalid)
+	}
+
+	return decoded, nil
+}
+
+// Formats SHA-256 fingerprint of the certificate the same way as Proxmox VE does.
+func formatTLSFingerprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+
+	parts := make([]string, 0, len(sum))
+	for _, b := range sum {
+		parts = append(parts, fmt.Sprintf("%02X", b))
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// Returns verification function which accepts only connections with leaf certificate matching given fingerprint.
+func verifyTLSFingerprint(expected []byte) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) < 1 {
+			return fmt.Errorf("%w: server did not present any certificate", ErrTLSFingerprintMismatch)
+		}
+
+		leaf := state.PeerCertificates[0]
+		actual := sha256.Sum256(leaf.Raw)
+
+		if !bytes.Equal(expected, actual[:]) {
+			return fmt.Errorf("%w: server='%s' presented certificate with fingerprint='%s'", ErrTLSFingerprintMismatch, state.ServerName, formatTLSFingerprint(leaf))
+		}
+
+		return nil
+	}
+}
+
+// Client certificate and key pair loaded from disk, reloaded whenever any of the files is modified.
+type clientCertificate struct {
+	certPath string
+	keyPath  string
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// Returns client certificate, reloading it from disk if files were modified since last load.
+func (c *clientCertificate) get() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	certModTime, keyModTime, err := c.modTimes()
+	if err != nil {
+		return c.fallback(err)
+	}
+
+	if c.certificate != nil && certModTime.Equal(c.certModTime) && keyModTime.Equal(c.keyModTime) {
+		return c.certificate, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		// Files might be rotated one by one, so mismatched pair is retried on next handshake
+		return c.fallback(fmt.Errorf("failed to load TLS client certificate from cert_path='%s' key_path='%s': %w", c.certPath, c.keyPath, err))
+	}
+
+	c.certificate = &certificate
+	c.certModTime = certModTime
+	c.keyModTime = keyModTime
+
+	return c.certificate, nil
+}
+
+// Returns modification times of certificate and key files.
+func (c *clientCertificate) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(c.certPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to stat TLS client certificate path='%s': %w", c.certPath, err)
+	}
+
+	keyInfo, err := os.Stat(c.keyPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to stat TLS client key path='%s': %w", c.keyPath, err)
+	}
+
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// Returns previously loaded certificate if there is one, otherwise given error.
+func (c *clientCertificate) fallback(err error) (*tls.Certificate, error) {
+	if c.certificate != nil {
+		return c.certificate, nil
+	}
+
+	return nil, err
+}
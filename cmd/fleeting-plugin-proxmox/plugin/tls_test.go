@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTLSTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func requestWithTLSSettings(t *testing.T, server *httptest.Server, settings Settings) error {
+	t.Helper()
+
+	ig := InstanceGroup{Settings: settings}
+
+	tlsConfig, err := ig.getTLSConfig()
+	require.NoError(t, err)
+
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	response, err := client.Get(server.URL) //nolint:noctx
+	if err == nil {
+		response.Body.Close()
+	}
+
+	return err //nolint:wrapcheck
+}
+
+func TestInstanceGroup_getTLSConfig(t *testing.T) {
+	server := newTLSTestServer(t)
+	fingerprint := formatTLSFingerprint(server.Certificate())
+
+	caCertPath := path.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caCertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	otherFingerprint := strings.Repeat("00:", 31) + "00"
+
+	// Unknown CA
+	require.Error(t, requestWithTLSSettings(t, server, Settings{}))
+
+	// Custom CA
+	require.NoError(t, requestWithTLSSettings(t, server, Settings{CACertPath: caCertPath}))
+
+	// Pinned certificate, in lowercase and without colons as well
+	require.NoError(t, requestWithTLSSettings(t, server, Settings{TLSFingerprint: fingerprint}))
+	require.NoError(t, requestWithTLSSettings(t, server, Settings{TLSFingerprint: strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))}))
+	require.NoError(t, requestWithTLSSettings(t, server, Settings{CACertPath: caCertPath, TLSFingerprint: fingerprint}))
+
+	// Pinned certificate mismatch
+	err := requestWithTLSSettings(t, server, Settings{TLSFingerprint: otherFingerprint})
+	require.ErrorIs(t, err, ErrTLSFingerprintMismatch)
+
+	err = requestWithTLSSettings(t, server, Settings{CACertPath: caCertPath, TLSFingerprint: otherFingerprint})
+	require.ErrorIs(t, err, ErrTLSFingerprintMismatch)
+}
+
+func TestInstanceGroup_getTLSConfig_invalidCACert(t *testing.T) {
+	ig := InstanceGroup{Settings: Settings{CACertPath: path.Join(t.TempDir(), "missing.pem")}}
+
+	_, err := ig.getTLSConfig()
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	ig.Settings.CACertPath = path.Join(t.TempDir(), "invalid.pem")
+	require.NoError(t, os.WriteFile(ig.Settings.CACertPath, []byte("not a certificate"), 0o600))
+
+	_, err = ig.getTLSConfig()
+	require.ErrorIs(t, err, ErrTLSCACertInvalid)
+}
+
+func Test_parseTLSFingerprint(t *testing.T) {
+	_, err := parseTLSFingerprint("AB:CD")
+	require.ErrorIs(t, err, ErrTLSFingerprintInvalid)
+
+	_, err = parseTLSFingerprint(strings.Repeat("ZZ:", 31) + "ZZ")
+	require.ErrorIs(t, err, ErrTLSFingerprintInvalid)
+
+	fingerprint, err := parseTLSFingerprint(strings.Repeat("ab:", 31) + "AB")
+	require.NoError(t, err)
+	require.Len(t, fingerprint, 32)
+}
+
+// Writes new self-signed client certificate and its key to given paths, returns the certificate.
+func writeTestClientCertificate(t *testing.T, certPath, keyPath string, serial int64) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "fleeting-plugin-proxmox"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return certificate
+}
+
+func TestInstanceGroup_getTLSConfig_clientCertificate(t *testing.T) {
+	var presented *x509.Certificate
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = r.TLS.PeerCertificates[0]
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	directory := t.TempDir()
+	certPath := path.Join(directory, "client.pem")
+	keyPath := path.Join(directory, "client.key")
+
+	// Missing client certificate is rejected by the server
+	require.Error(t, requestWithTLSSettings(t, server, Settings{InsecureSkipTLSVerify: true}))
+
+	expected := writeTestClientCertificate(t, certPath, keyPath, 1)
+
+	require.NoError(t, requestWithTLSSettings(t, server, Settings{InsecureSkipTLSVerify: true, TLSClientCertPath: certPath, TLSClientKeyPath: keyPath}))
+	require.Equal(t, expected.Raw, presented.Raw)
+}
+
+func Test_clientCertificate_get(t *testing.T) {
+	directory := t.TempDir()
+	certPath := path.Join(directory, "client.pem")
+	keyPath := path.Join(directory, "client.key")
+
+	clientCertificate := &clientCertificate{certPath: certPath, keyPath: keyPath}
+
+	// Nothing to load yet
+	_, err := clientCertificate.get()
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	first := writeTestClientCertificate(t, certPath, keyPath, 1)
+
+	certificate, err := clientCertificate.get()
+	require.NoError(t, err)
+	require.Equal(t, first.Raw, certificate.Certificate[0])
+
+	// Rotated pair is loaded
+	second := writeTestClientCertificate(t, certPath, keyPath, 2)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certPath, future, future))
+	require.NoError(t, os.Chtimes(keyPath, future, future))
+
+	certificate, err = clientCertificate.get()
+	require.NoError(t, err)
+	require.Equal(t, second.Raw, certificate.Certificate[0])
+
+	// Half-rotated pair keeps previous certificate in use
+	require.NoError(t, os.WriteFile(keyPath, []byte("not a key"), 0o600))
+	require.NoError(t, os.Chtimes(keyPath, future.Add(time.Minute), future.Add(time.Minute)))
+
+	certificate, err = clientCertificate.get()
+	require.NoError(t, err)
+	require.Equal(t, second.Raw, certificate.Certificate[0])
+}
@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Parameters of TOTP codes used by Proxmox VE.
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+)
+
+var ErrInvalidTOTPSecret = errors.New("invalid TOTP secret")
+
+// Decodes base32 TOTP secret, spaces, lowercase letters and missing padding are accepted.
+func parseTOTPSecret(secret string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	normalized = strings.TrimRight(normalized, "=")
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(normalized)
+	if err != nil || len(key) < 1 {
+		return nil, fmt.Errorf("%w: must be base32 encoded", ErrInvalidTOTPSecret)
+	}
+
+	return key, nil
+}
+
+// Generates TOTP code (RFC 6238) for given time.
+func generateTOTP(secret string, now time.Time) (string, error) {
+	key, err := parseTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(now.Unix()/int64(totpPeriod.Seconds())))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%1_000_000), nil
+}
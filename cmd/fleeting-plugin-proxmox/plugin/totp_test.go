@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_generateTOTP(t *testing.T) {
+	// Test vectors from RFC 6238, truncated to 6 digits
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := generateTOTP(secret, time.Unix(tt.unix, 0))
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, code)
+	}
+
+	// Lowercase secret with spaces
+	code, err := generateTOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0))
+	require.NoError(t, err)
+	require.Equal(t, "287082", code)
+}
+
+func Test_parseTOTPSecret(t *testing.T) {
+	_, err := parseTOTPSecret("not base32!")
+	require.ErrorIs(t, err, ErrInvalidTOTPSecret)
+
+	_, err = parseTOTPSecret("")
+	require.ErrorIs(t, err, ErrInvalidTOTPSecret)
+
+	key, err := parseTOTPSecret("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	require.NoError(t, err)
+	require.Equal(t, []byte("12345678901234567890"), key)
+}